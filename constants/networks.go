@@ -0,0 +1,24 @@
+package constants
+
+// DefaultNetwork is the Livepeer network the exporter fetches data for by default.
+const DefaultNetwork = "arbitrum-mainnet"
+
+// Network contains the default upstream endpoints of a Livepeer network. Endpoints that are empty
+// are not available on the network.
+type Network struct {
+	SubgraphEndpoint            string // The Livepeer subgraph GraphQL API endpoint.
+	ScoreEndpointTemplate       string // The orchestrator score API endpoint, formatted with the orchestrator address.
+	TestStreamsEndpointTemplate string // The test streams API endpoint, formatted with the orchestrator address.
+}
+
+// Networks maps the supported network names to their default endpoints.
+var Networks = map[string]Network{
+	"arbitrum-mainnet": {
+		SubgraphEndpoint:            LivePeerSubgraphEndpoint,
+		ScoreEndpointTemplate:       "https://explorer.livepeer.org/api/score/%s",
+		TestStreamsEndpointTemplate: "https://leaderboard-serverless.vercel.app/api/raw_stats?orchestrator=%s",
+	},
+	"arbitrum-testnet": {
+		SubgraphEndpoint: "https://api.thegraph.com/subgraphs/name/livepeer/arbitrum-goerli",
+	},
+}
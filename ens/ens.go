@@ -0,0 +1,187 @@
+// Package ens resolves Ethereum Name Service (ENS) names to addresses, and addresses to their primary
+// names, through an Ethereum JSON-RPC endpoint.
+package ens
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"livepeer-exporter/ethrpc"
+	"livepeer-exporter/metrics"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// registryAddress is the address of the ENS registry on Ethereum mainnet.
+const registryAddress = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+
+// Function selectors of the ENS contract calls.
+const (
+	resolverSelector = "0178b8bf" // resolver(bytes32)
+	addrSelector     = "3b3b57de" // addr(bytes32)
+	nameSelector     = "691f3431" // name(bytes32)
+)
+
+// ErrNotFound is returned when an ENS name does not resolve to an address.
+var ErrNotFound = errors.New("ENS name does not resolve to an address")
+
+// IsName reports whether value is an ENS name rather than a hex address.
+func IsName(value string) bool {
+	return strings.HasSuffix(strings.ToLower(value), ".eth")
+}
+
+// namehash returns the ENS namehash of a name.
+func namehash(name string) [32]byte {
+	var node [32]byte
+	if name == "" {
+		return node
+	}
+
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		labelHash := keccak256([]byte(labels[i]))
+		node = keccak256(append(node[:], labelHash[:]...))
+	}
+	return node
+}
+
+// call executes an 'eth_call' of a contract function that takes a single bytes32 argument and returns
+// the ABI encoded result, which is at least 32 bytes long.
+func call(client *http.Client, rpcURL string, to string, selector string, node [32]byte) ([]byte, error) {
+	callObject := map[string]string{"to": to, "data": "0x" + selector + hex.EncodeToString(node[:])}
+	encoded, err := ethrpc.Call(client, rpcURL, "eth_call", callObject, "latest")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := hex.DecodeString(strings.TrimPrefix(encoded, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	if len(result) < 32 {
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
+
+// addressFromWord returns the address contained in the last 20 bytes of an ABI encoded word. It
+// returns an empty string for the zero address.
+func addressFromWord(word []byte) string {
+	address := word[12:32]
+	if bytes.Equal(address, make([]byte, 20)) {
+		return ""
+	}
+	return "0x" + hex.EncodeToString(address)
+}
+
+// Resolve resolves an ENS name to a lowercase hex address using the Ethereum mainnet JSON-RPC
+// endpoint at rpcURL. It returns ErrNotFound when the name has no resolver or address.
+func Resolve(client *http.Client, rpcURL string, name string) (string, error) {
+	node := namehash(name)
+
+	resolverWord, err := call(client, rpcURL, registryAddress, resolverSelector, node)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve resolver of '%s': %w", name, err)
+	}
+	resolver := addressFromWord(resolverWord)
+	if resolver == "" {
+		return "", fmt.Errorf("'%s' has no resolver: %w", name, ErrNotFound)
+	}
+
+	addrWord, err := call(client, rpcURL, resolver, addrSelector, node)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve address of '%s': %w", name, err)
+	}
+	address := addressFromWord(addrWord)
+	if address == "" {
+		return "", fmt.Errorf("'%s' has no address: %w", name, ErrNotFound)
+	}
+	return address, nil
+}
+
+// stringFromResult returns the string contained in an ABI encoded result. It returns an empty string
+// when the result is malformed.
+func stringFromResult(result []byte) string {
+	offset := new(big.Int).SetBytes(result[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(result)-32) {
+		return ""
+	}
+	start := offset.Uint64() + 32
+	length := new(big.Int).SetBytes(result[start-32 : start])
+	if !length.IsUint64() || length.Uint64() > uint64(len(result))-start {
+		return ""
+	}
+	return string(result[start : start+length.Uint64()])
+}
+
+// LookupName returns the primary ENS name of address using the Ethereum mainnet JSON-RPC endpoint at
+// rpcURL. The name is only returned when it resolves back to address, since anyone can set a reverse
+// record to any name. It returns ErrNotFound when the address has no (valid) primary name.
+func LookupName(client *http.Client, rpcURL string, address string) (string, error) {
+	address = strings.ToLower(address)
+	node := namehash(strings.TrimPrefix(address, "0x") + ".addr.reverse")
+
+	resolverWord, err := call(client, rpcURL, registryAddress, resolverSelector, node)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve reverse resolver of '%s': %w", address, err)
+	}
+	resolver := addressFromWord(resolverWord)
+	if resolver == "" {
+		return "", fmt.Errorf("'%s' has no reverse resolver: %w", address, ErrNotFound)
+	}
+
+	result, err := call(client, rpcURL, resolver, nameSelector, node)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve name of '%s': %w", address, err)
+	}
+	name := stringFromResult(result)
+	if name == "" {
+		return "", fmt.Errorf("'%s' has no name: %w", address, ErrNotFound)
+	}
+
+	resolved, err := Resolve(client, rpcURL, name)
+	if err != nil {
+		return "", err
+	}
+	if resolved != address {
+		return "", fmt.Errorf("'%s' resolves to '%s' instead of '%s': %w", name, resolved, address, ErrNotFound)
+	}
+	return name, nil
+}
+
+// Watch re-resolves the ENS name every interval until ctx is cancelled and logs a warning when it
+// resolves to a different address than the address the exporter was started with. The
+// 'livepeer_orch_ens_address_info' metric always reflects the last resolved address.
+func Watch(ctx context.Context, client *http.Client, rpcURL string, name string, address string, interval time.Duration) {
+	metrics.ENSAddressInfo.WithLabelValues(name, address).Set(1)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	current := address
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resolved, err := Resolve(client, rpcURL, name)
+			if err != nil {
+				log.Printf("Error re-resolving ENS name '%s': %v", name, err)
+				continue
+			}
+			if resolved == current {
+				continue
+			}
+
+			log.Printf("WARNING: ENS name '%s' now resolves to '%s' instead of '%s'. Restart the exporter to fetch data for the new address", name, resolved, address)
+			metrics.ENSAddressInfo.Reset()
+			metrics.ENSAddressInfo.WithLabelValues(name, resolved).Set(1)
+			current = resolved
+		}
+	}
+}
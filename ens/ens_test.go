@@ -0,0 +1,50 @@
+package ens
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"sentence", "The quick brown fox jumps over the lazy dog", "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		{"one byte below rate", strings.Repeat("a", keccakRate-1), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{"exactly rate", strings.Repeat("a", keccakRate), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{"multiple blocks", strings.Repeat("a", 300), "5b7e0e47a96f32a88b4f14ca177982790807c40e1a105742ba0fc1babe1ef826"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := keccak256([]byte(tt.input))
+			if got := hex.EncodeToString(hash[:]); got != tt.want {
+				t.Errorf("keccak256() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamehash(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"eth", "93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae"},
+		{"foo.eth", "de9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+		{"Foo.ETH", "de9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := namehash(tt.name)
+			if got := hex.EncodeToString(hash[:]); got != tt.want {
+				t.Errorf("namehash(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
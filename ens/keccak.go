@@ -0,0 +1,93 @@
+package ens
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// keccakRate is the number of bytes absorbed per Keccak-256 permutation.
+const keccakRate = 136
+
+// keccakRoundConstants are the round constants of the Keccak-f[1600] permutation.
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// keccakRotations are the rotation offsets of the lanes, indexed by x+5*y.
+var keccakRotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// keccakF applies the Keccak-f[1600] permutation to the state.
+func keccakF(a *[25]uint64) {
+	for round := 0; round < 24; round++ {
+		// Theta.
+		var c [5]uint64
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[x+y] ^= d
+			}
+		}
+
+		// Rho and pi.
+		var b [25]uint64
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+
+		// Chi.
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[x+y] = b[x+y] ^ (^b[(x+1)%5+y] & b[(x+2)%5+y])
+			}
+		}
+
+		// Iota.
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// keccak256 returns the Keccak-256 hash of data as used by Ethereum, which differs from SHA3-256
+// in its padding.
+func keccak256(data []byte) [32]byte {
+	var state [25]uint64
+
+	// Pad the data to a multiple of the rate.
+	padded := make([]byte, len(data), len(data)+keccakRate)
+	copy(padded, data)
+	padded = append(padded, 0x01)
+	for len(padded)%keccakRate != 0 {
+		padded = append(padded, 0x00)
+	}
+	padded[len(padded)-1] |= 0x80
+
+	// Absorb the data.
+	for block := 0; block < len(padded); block += keccakRate {
+		for i := 0; i < keccakRate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[block+8*i:])
+		}
+		keccakF(&state)
+	}
+
+	// Squeeze the hash.
+	var hash [32]byte
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(hash[8*i:], state[i])
+	}
+	return hash
+}
@@ -0,0 +1,59 @@
+// Package ethrpc provides a minimal client for Ethereum JSON-RPC endpoints.
+package ethrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// request represents an Ethereum JSON-RPC request.
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// response represents an Ethereum JSON-RPC response.
+type response struct {
+	Result string
+	Error  *struct {
+		Code    int
+		Message string
+	}
+}
+
+// Call calls the JSON-RPC method with the given params on the endpoint at rpcURL and returns the
+// hex encoded result.
+func Call(client *http.Client, rpcURL string, method string, params ...interface{}) (string, error) {
+	body, err := json.Marshal(request{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := client.Post(rpcURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	var rpcResponse response
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResponse); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if rpcResponse.Error != nil {
+		return "", fmt.Errorf("RPC error %d: %s", rpcResponse.Error.Code, rpcResponse.Error.Message)
+	}
+	return rpcResponse.Result, nil
+}
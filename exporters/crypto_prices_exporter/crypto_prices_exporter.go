@@ -1,32 +1,47 @@
 // Package crypto_prices_exporter implements a crypto prices exporter that fetches data from the
 // https://api.coinbase.com/v2/exchange-rates?currency=USD API endpoint and exposes information
-// about several crypto currencies that are relevant to Livepeer.
+// about several crypto currencies that are relevant to Livepeer. The prices can be cached on disk so
+// that restarts do not use up the rate limit of the API.
 package crypto_prices_exporter
 
 import (
+	"context"
+	"encoding/json"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
 	"livepeer-exporter/util"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "crypto_prices"
+
 var (
-	getCryptoPricesEndpoint = "https://api.coinbase.com/v2/exchange-rates?currency=USD"
+	// DefaultEndpoint is the endpoint the crypto prices are fetched from by default.
+	DefaultEndpoint = "https://api.coinbase.com/v2/exchange-rates?currency=USD"
 )
 
 // cryptoPricesResponse represents the structure of the data returned by the API.
 type cryptoPricesResponse struct {
-	sync.Mutex
-
 	Data struct {
 		Currency string
-		Rates    map[string]string `json:"rates"`
+		Rates    map[string]util.Numeric `json:"rates"`
 	}
 }
 
+// priceCache represents the structure of the file the data returned by the API is cached in.
+type priceCache struct {
+	FetchedAt time.Time             `json:"fetched_at"`
+	Response  *cryptoPricesResponse `json:"response"`
+}
+
 // cryptoPrices represents the structure of the data returned by the API, parsed into a struct.
 type cryptoPrices struct {
 	LPTUSDPrice float64
@@ -38,17 +53,24 @@ type cryptoPrices struct {
 // CryptoPricesExporter fetches data from the API and exposes data about the crypto prices via Prometheus metrics.
 type CryptoPricesExporter struct {
 	// Metrics.
-	LPTPrice *prometheus.GaugeVec
-	ETHPrice *prometheus.GaugeVec
+	LPTPrice    *prometheus.GaugeVec
+	ETHPrice    *prometheus.GaugeVec
+	FetchErrors prometheus.Counter
+	registry    *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
 	fetchInterval        time.Duration // How often to fetch data.
 	updateInterval       time.Duration // How often to update metrics.
+	clock                runner.Clock  // The clock that drives the fetch and update loops.
 	cryptoPricesEndpoint string        // The endpoint to fetch data from.
+	cacheFile            string        // The file the data is cached in. Empty when caching is disabled.
+	cacheTTL             time.Duration // How long fetched data is used before it is fetched again.
 
 	// Data.
+	mu                   sync.RWMutex          // Guards the data returned by the API.
 	cryptoPricesResponse *cryptoPricesResponse // The data returned by the API.
 	cryptoPrices         *cryptoPrices         // The data returned by the  API, parsed into a struct.
+	fetchedAt            time.Time             // When the data was fetched. Zero until it was fetched.
 
 	// Fetchers.
 	cryptoPricesFetcher fetcher.Fetcher
@@ -64,18 +86,27 @@ func (m *CryptoPricesExporter) initMetrics() {
 		Name: "ETH_price",
 		Help: "Ethereum  price.",
 	}, []string{"currency"})
+	m.FetchErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "livepeer_price_fetch_errors_total",
+		Help: "The total number of failed crypto prices fetches.",
+	})
 }
 
-// registerMetrics registers the crypto prices metrics with Prometheus.
+// registerMetrics registers the crypto prices metrics with the exporter's registry.
 func (m *CryptoPricesExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.LPTPrice,
 		m.ETHPrice,
+		m.FetchErrors,
 	)
 }
 
 // parseMetrics parses the values from the cryptoResponse and populates the cryptoPricesResponse struct.
 func (m *CryptoPricesExporter) parseMetrics() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Retrieve dollar prices.
 	LPTUSDPrice, err := util.StringToFloat64(m.cryptoPricesResponse.Data.Rates["LPT"])
 	if err != nil {
@@ -103,9 +134,7 @@ func (m *CryptoPricesExporter) parseMetrics() {
 // updateMetrics updates the metrics with the data fetched from the Coinbase exchange-rates API.
 func (m *CryptoPricesExporter) updateMetrics() {
 	// Parse the metrics from the response data.
-	m.cryptoPricesResponse.Mutex.Lock()
 	m.parseMetrics()
-	m.cryptoPricesResponse.Mutex.Unlock()
 
 	// Set the metrics.
 	m.LPTPrice.WithLabelValues("USD").Set(m.cryptoPrices.LPTUSDPrice)
@@ -114,20 +143,41 @@ func (m *CryptoPricesExporter) updateMetrics() {
 	m.ETHPrice.WithLabelValues("EUR").Set(m.cryptoPrices.ETHEURPrice)
 }
 
-// NewCryptoPricesExporter creates a new CryptoPricesExporter.
-func NewCryptoPricesExporter(fetchInterval time.Duration, updateInterval time.Duration) *CryptoPricesExporter {
+// NewCryptoPricesExporter creates a new CryptoPricesExporter. When cacheFile is set, fetched data is
+// written to it and data in it that is younger than cacheTTL is used instead of fetching the data
+// again. When apiKey is set, it is sent in the apiKeyHeader request header.
+func NewCryptoPricesExporter(fetchInterval time.Duration, updateInterval time.Duration, cacheFile string, cacheTTL time.Duration, apiKey string, apiKeyHeader string, endpoint string, client *http.Client) *CryptoPricesExporter {
 	exporter := &CryptoPricesExporter{
 		fetchInterval:        fetchInterval,
 		updateInterval:       updateInterval,
-		cryptoPricesEndpoint: getCryptoPricesEndpoint,
+		clock:                runner.RealClock,
+		cryptoPricesEndpoint: endpoint,
+		cacheFile:            cacheFile,
+		cacheTTL:             cacheTTL,
 		cryptoPricesResponse: &cryptoPricesResponse{},
 		cryptoPrices:         &cryptoPrices{},
 	}
 
+	// Create request headers.
+	var headers map[string][]string
+	if apiKey != "" {
+		headers = map[string][]string{
+			apiKeyHeader: {apiKey},
+		}
+	}
+
 	// Initialize fetcher.
 	exporter.cryptoPricesFetcher = fetcher.Fetcher{
-		URL:  exporter.cryptoPricesEndpoint,
-		Data: &exporter.cryptoPricesResponse,
+		URL:     exporter.cryptoPricesEndpoint,
+		Headers: headers,
+		Client:  client,
+	}
+
+	// Load the data cached by a previous run.
+	if cacheFile != "" {
+		if err := exporter.loadCache(); err != nil && !os.IsNotExist(err) {
+			log.Printf("%s exporter: error loading crypto prices cache '%s': %v", exporterName, cacheFile, err)
+		}
 	}
 
 	// Initialize metrics.
@@ -137,30 +187,113 @@ func NewCryptoPricesExporter(fetchInterval time.Duration, updateInterval time.Du
 	return exporter
 }
 
-func (m *CryptoPricesExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.cryptoPricesFetcher.FetchData()
-	m.updateMetrics()
+// loadCache loads the data from the cache file.
+func (m *CryptoPricesExporter) loadCache() error {
+	data, err := os.ReadFile(m.cacheFile)
+	if err != nil {
+		return err
+	}
+	var cache priceCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return err
+	}
+	if cache.Response == nil {
+		return nil
+	}
 
-	// Start fetchers in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
+	m.mu.Lock()
+	m.cryptoPricesResponse = cache.Response
+	m.fetchedAt = cache.FetchedAt
+	m.mu.Unlock()
+	return nil
+}
 
-		for range ticker.C {
-			m.cryptoPricesResponse.Mutex.Lock()
-			m.cryptoPricesFetcher.FetchData()
-			m.cryptoPricesResponse.Mutex.Unlock()
-		}
-	}()
+// writeCache writes the data to the cache file. The data is written to a temporary file that is
+// renamed afterwards, so that the cache file is never left partially written.
+func (m *CryptoPricesExporter) writeCache(cache priceCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.cacheFile), filepath.Base(m.cacheFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.cacheFile)
+}
+
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// Data that is younger than the cache TTL is kept instead of fetching it again. It reports
+// whether the data was fetched.
+func (m *CryptoPricesExporter) fetchData() bool {
+	now := m.clock.Now()
+	m.mu.RLock()
+	fresh := !m.fetchedAt.IsZero() && now.Sub(m.fetchedAt) < m.cacheTTL
+	m.mu.RUnlock()
+	if fresh {
+		return true
+	}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+	response := &cryptoPricesResponse{}
+	m.cryptoPricesFetcher.Data = response
+	if err := m.cryptoPricesFetcher.FetchData(); err != nil {
+		log.Printf("%s exporter: error fetching crypto prices data: %v", exporterName, err)
+		m.FetchErrors.Inc()
+		return false
+	}
+
+	m.mu.Lock()
+	m.cryptoPricesResponse = response
+	m.fetchedAt = now
+	m.mu.Unlock()
 
-		for range ticker.C {
-			m.updateMetrics()
+	if m.cacheFile != "" {
+		if err := m.writeCache(priceCache{FetchedAt: now, Response: response}); err != nil {
+			log.Printf("%s exporter: error writing crypto prices cache '%s': %v", exporterName, m.cacheFile, err)
 		}
-	}()
+	}
+	return true
+}
+
+// ETHUSDPrice returns the ETH price in USD of the data last fetched from the API, so that other
+// exporters can value fees without fetching the price themselves. It reports false when no valid
+// price was fetched yet.
+func (m *CryptoPricesExporter) ETHUSDPrice() (float64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rate, err := m.cryptoPricesResponse.Data.Rates["ETH"].Float64()
+	if err != nil || rate <= 0 {
+		return 0, false
+	}
+	return 1 / rate, true
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *CryptoPricesExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the CryptoPricesExporter in logs and metrics.
+func (m *CryptoPricesExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the CryptoPricesExporter's metrics are registered with.
+func (m *CryptoPricesExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the CryptoPricesExporter and blocks until ctx is cancelled.
+func (m *CryptoPricesExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
@@ -0,0 +1,26 @@
+package crypto_prices_exporter
+
+import (
+	"livepeer-exporter/testutil"
+	"testing"
+	"time"
+)
+
+func TestETHUSDPrice(t *testing.T) {
+	server := testutil.NewServer(t, testutil.Route{Fixture: "crypto_prices.json"})
+	exporter := NewCryptoPricesExporter(time.Minute, time.Minute, "", 0, "", "", server.URL, server.Client())
+
+	if _, ok := exporter.ETHUSDPrice(); ok {
+		t.Error("ETHUSDPrice() is known before the first fetch")
+	}
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	price, ok := exporter.ETHUSDPrice()
+	if !ok {
+		t.Fatal("ETHUSDPrice() is unknown after a fetch")
+	}
+	if want := 1 / 0.000425; price != want {
+		t.Errorf("ETHUSDPrice() = %v, want %v", price, want)
+	}
+}
@@ -4,24 +4,59 @@
 package orch_delegators_exporter
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	delegatorsEndpoint = constants.LivePeerSubgraphEndpoint
-)
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_delegators"
 
-// graphqlQuery represents the GraphQL query to fetch data from the GraphQL API.
+// graphqlQuery represents the GraphQL query to fetch data from the GraphQL API. It only fetches the
+// first page of delegators.
 const graphqlQueryTemplate = `
 {
-	delegators(where: {delegate: "%s"}) {
+	delegators(where: {delegate: "%s"}, orderBy: id, orderDirection: asc, first: %d) {
+		id
+		startRound
+		bondedAmount
+		fees
+	}
+	transcoder(id: "%s") {
+		totalStake
+	}
+	protocol(id: "0") {
+		currentRound {
+			id
+		}
+	}
+	_meta {
+		block {
+			number
+		}
+		hasIndexingErrors
+	}
+}
+`
+
+// delegatorsPageQueryTemplate represents the GraphQL query to fetch a page of the orchestrator's
+// delegators, starting after the given delegator ID.
+const delegatorsPageQueryTemplate = `
+{
+	delegators(where: {delegate: "%s", id_gt: "%s"}, orderBy: id, orderDirection: asc, first: %d) {
 		id
 		startRound
 		bondedAmount
@@ -30,19 +65,34 @@ const graphqlQueryTemplate = `
 }
 `
 
+// delegatorsPageSize is the number of delegators fetched per query.
+const delegatorsPageSize = 1000
+
 // delegatorsResponse represents the structure of the delegators field contained in the GraphQL API response.
 type delegator struct {
 	ID           string
 	StartRound   string
-	BondedAmount string
-	Fees         string
+	BondedAmount util.Numeric
+	Fees         util.Numeric
 }
 
 // delegatorsResponse represents the structure of the GraphQL API response.
 type delegatorsResponse struct {
-	sync.Mutex
+	Data struct {
+		Delegators []delegator
+		Transcoder *struct {
+			TotalStake util.Numeric
+		}
+		Protocol struct {
+			CurrentRound struct {
+				ID string
+			}
+		}
+	}
+}
 
-	// Response data.
+// delegatorsPageResponse represents the structure of the delegators page GraphQL API response.
+type delegatorsPageResponse struct {
 	Data struct {
 		Delegators []delegator
 	}
@@ -51,22 +101,37 @@ type delegatorsResponse struct {
 // OrchDelegatorsExporter fetches data from the API and exposes orchestrator's delegators metrics via Prometheus.
 type OrchDelegatorsExporter struct {
 	// Metrics.
-	BondedAmount   *prometheus.GaugeVec
-	StartRound     *prometheus.GaugeVec
-	DelegatorCount prometheus.Gauge
-	CollectedFees  *prometheus.GaugeVec
+	BondedAmount     *prometheus.GaugeVec
+	StartRound       *prometheus.GaugeVec
+	DelegatorCount   prometheus.Gauge
+	CollectedFees    *prometheus.GaugeVec
+	StakeShare       *prometheus.GaugeVec
+	StakeRounds      *prometheus.GaugeVec
+	TotalStakeRounds prometheus.Gauge
+	Joined           prometheus.Counter
+	Left             prometheus.Counter
+	ReconcileDelta   *prometheus.GaugeVec
+	LargestStake     *prometheus.GaugeVec
+	registry         *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
 	fetchInterval              time.Duration // How often to fetch data.
 	updateInterval             time.Duration // How often to update metrics.
+	clock                      runner.Clock  // The clock that drives the fetch and update loops.
+	orchAddress                string        // The address of the orchestrator.
 	orchDelegatorsEndpoint     string        // The endpoint to fetch data from.
 	orchDelegatorsGraphqlQuery string        // The GraphQL query to fetch data from the GraphQL API.
+	topDelegators              int           // The number of largest delegators whose per-delegator metrics are exposed. Zero exposes all.
+	watchedDelegators          []string      // The delegators whose per-delegator metrics are always exposed.
 
 	// Data.
+	mu             sync.RWMutex        // Guards the data returned by the API.
 	orchDelegators *delegatorsResponse // The data returned by the API.
+	prevDelegators map[string]bool     // The delegator IDs of the previous fetch. Nil until the first fetch. Only used by fetchData.
 
 	// Fetchers.
 	orchDelegatorsFetcher fetcher.Fetcher
+	delegatorsPageFetcher fetcher.Fetcher
 }
 
 // initMetrics initializes the orchestrator delegators metrics.
@@ -92,48 +157,202 @@ func (m *OrchDelegatorsExporter) initMetrics() {
 		},
 		[]string{"id"},
 	)
+	m.StakeShare = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_delegator_stake_share",
+			Help: "The share of the total stake of the orchestrator that each delegator bonded.",
+		},
+		[]string{"id"},
+	)
+	m.StakeRounds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_delegator_stake_rounds",
+			Help: "The bonded amount of each delegator multiplied by the number of rounds since it started delegating.",
+		},
+		[]string{"id"},
+	)
+	m.TotalStakeRounds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_delegators_stake_rounds",
+			Help: "The sum of the stake rounds of all delegators of the orchestrator.",
+		},
+	)
 	m.DelegatorCount = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "livepeer_orch_delegator_count",
 			Help: "The total number of delegators that are staked with the orchestrator.",
 		},
 	)
+	m.Joined = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "livepeer_orch_delegators_joined_total",
+			Help: "The number of delegators that started delegating to the orchestrator since the exporter started.",
+		},
+	)
+	m.Left = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "livepeer_orch_delegators_left_total",
+			Help: "The number of delegators that stopped delegating to the orchestrator since the exporter started.",
+		},
+	)
+	// NOTE: A vector without labels is used so that it is only exposed once the total stake is known.
+	m.ReconcileDelta = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_delegated_stake_reconciliation_delta",
+			Help: "The delegated stake of the orchestrator minus the sum of the bonded amounts of the fetched delegators in LPT.",
+		},
+		nil,
+	)
+	m.LargestStake = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_largest_delegator_stake",
+			Help: "The bonded amount of the delegator with the largest stake, excluding the orchestrator itself, in LPT.",
+		},
+		[]string{"id"},
+	)
 }
 
-// registerMetrics registers the orchestrator delegators metrics with Prometheus.
+// registerMetrics registers the orchestrator delegators metrics with the exporter's registry.
 func (m *OrchDelegatorsExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.BondedAmount,
 		m.StartRound,
 		m.DelegatorCount,
 		m.CollectedFees,
+		m.StakeShare,
+		m.StakeRounds,
+		m.TotalStakeRounds,
+		m.Joined,
+		m.Left,
+		m.ReconcileDelta,
+		m.LargestStake,
 	)
 }
 
 // updateMetrics updates the metrics with the data fetched from the stonk.rocks orchestrator API.
 func (m *OrchDelegatorsExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	// Set the DelegatorCount metric by counting the length of the Delegators slice.
 	m.DelegatorCount.Set(float64(len(m.orchDelegators.Data.Delegators)))
 
-	// Set the BondedAmount and StartRound metrics for each delegator.
+	// Calculate the total bonded amount of all delegators and find the largest delegator.
+	// NOTE: The orchestrator's self-stake is not counted as largest delegator, since it is not at risk
+	// of leaving.
+	var totalBondedAmount, largestBondedAmount float64
+	largestDelegator := ""
 	for _, delegator := range m.orchDelegators.Data.Delegators {
-		bondedAmount, _ := strconv.ParseFloat(delegator.BondedAmount, 64)
+		bondedAmount, _ := delegator.BondedAmount.Float64()
+		totalBondedAmount += bondedAmount
+		if delegator.ID != m.orchAddress && (largestDelegator == "" || bondedAmount > largestBondedAmount) {
+			largestDelegator = delegator.ID
+			largestBondedAmount = bondedAmount
+		}
+	}
+
+	// Set the LargestStake metric.
+	// NOTE: Reset so that only the current largest delegator is exposed. Skipped when there are no delegators.
+	m.LargestStake.Reset()
+	if largestDelegator != "" {
+		m.LargestStake.WithLabelValues(largestDelegator).Set(util.RoundTokenAmount(largestBondedAmount))
+	}
+
+	// Select the delegators whose per-delegator metrics are exposed.
+	// NOTE: The aggregated metrics always include all delegators.
+	exposed := m.exposedDelegators()
+
+	// Reset the delegator metrics so that departed delegators are removed.
+	m.BondedAmount.Reset()
+	m.StartRound.Reset()
+	m.CollectedFees.Reset()
+	m.StakeShare.Reset()
+	m.StakeRounds.Reset()
+
+	// Set the BondedAmount, StartRound, CollectedFees, StakeShare and StakeRounds metrics for each delegator.
+	// NOTE: The stake share is relative to the total stake of the orchestrator rather than the sum of the
+	// fetched delegators, and is skipped while the total stake is unknown. The stake rounds are skipped
+	// until the current round is known. Delegators whose start round has not been reached yet
+	// have zero stake rounds.
+	currentRound, currentRoundErr := strconv.ParseFloat(m.orchDelegators.Data.Protocol.CurrentRound.ID, 64)
+	var totalStake float64
+	if m.orchDelegators.Data.Transcoder != nil {
+		totalStake, _ = m.orchDelegators.Data.Transcoder.TotalStake.Float64()
+	}
+	var totalStakeRounds float64
+	for _, delegator := range m.orchDelegators.Data.Delegators {
+		bondedAmount, _ := delegator.BondedAmount.Float64()
 		startRound, _ := strconv.ParseFloat(delegator.StartRound, 64)
-		feesCollected, _ := strconv.ParseFloat(delegator.Fees, 64)
+		feesCollected, _ := delegator.Fees.Float64()
+		stakeRounds := bondedAmount * max(currentRound-startRound, 0)
+		if currentRoundErr == nil {
+			totalStakeRounds += stakeRounds
+		}
+		if !exposed[delegator.ID] {
+			continue
+		}
 
-		m.BondedAmount.WithLabelValues(delegator.ID).Set(bondedAmount)
+		m.BondedAmount.WithLabelValues(delegator.ID).Set(util.RoundTokenAmount(bondedAmount))
 		m.StartRound.WithLabelValues(delegator.ID).Set(startRound)
-		m.CollectedFees.WithLabelValues(delegator.ID).Set(feesCollected)
+		m.CollectedFees.WithLabelValues(delegator.ID).Set(util.RoundTokenAmount(feesCollected))
+		if totalStake > 0 {
+			m.StakeShare.WithLabelValues(delegator.ID).Set(bondedAmount / totalStake)
+		}
+		if currentRoundErr == nil {
+			m.StakeRounds.WithLabelValues(delegator.ID).Set(stakeRounds)
+		}
+	}
+	m.TotalStakeRounds.Set(totalStakeRounds)
+
+	// Set the ReconcileDelta metric.
+	// NOTE: The orchestrator's self-stake is both part of its total stake and the bonded amount of its
+	// own delegator, so the delegated stake minus the other delegators equals the total stake minus
+	// all delegators. Skipped when the orchestrator is not registered.
+	if m.orchDelegators.Data.Transcoder != nil {
+		if totalStake, err := m.orchDelegators.Data.Transcoder.TotalStake.Float64(); err == nil {
+			m.ReconcileDelta.WithLabelValues().Set(util.RoundTokenAmount(totalStake - totalBondedAmount))
+		}
+	}
+}
+
+// exposedDelegators returns the IDs of the delegators whose per-delegator metrics are exposed, which
+// are the topDelegators delegators with the largest bonded amount and the watched delegators.
+// NOTE: The caller must hold the read lock.
+func (m *OrchDelegatorsExporter) exposedDelegators() map[string]bool {
+	delegators := slices.Clone(m.orchDelegators.Data.Delegators)
+	if m.topDelegators > 0 {
+		slices.SortFunc(delegators, func(a, b delegator) int {
+			aBonded, _ := a.BondedAmount.Float64()
+			bBonded, _ := b.BondedAmount.Float64()
+			return cmp.Compare(bBonded, aBonded)
+		})
+		delegators = delegators[:min(m.topDelegators, len(delegators))]
+	}
+
+	exposed := make(map[string]bool)
+	for _, delegator := range delegators {
+		exposed[delegator.ID] = true
 	}
+	for _, id := range m.watchedDelegators {
+		exposed[id] = true
+	}
+	return exposed
 }
 
-// NewOrchDelegatorsExporter creates a new OrchDelegatorsExporter.
-func NewOrchDelegatorsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration) *OrchDelegatorsExporter {
+// NewOrchDelegatorsExporter creates a new OrchDelegatorsExporter. Only the per-delegator metrics of
+// the topDelegators largest delegators are exposed, or of all delegators when it is zero. The
+// per-delegator metrics of the watchedDelegators are always exposed.
+func NewOrchDelegatorsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, topDelegators int, watchedDelegators []string, endpoint string, client *http.Client) *OrchDelegatorsExporter {
 	exporter := &OrchDelegatorsExporter{
 		fetchInterval:              fetchInterval,
 		updateInterval:             updateInterval,
-		orchDelegatorsEndpoint:     delegatorsEndpoint,
-		orchDelegatorsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress),
+		clock:                      runner.RealClock,
+		orchAddress:                strings.ToLower(orchAddress),
+		orchDelegatorsEndpoint:     endpoint,
+		orchDelegatorsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress, delegatorsPageSize, orchAddress),
+		topDelegators:              topDelegators,
+		watchedDelegators:          watchedDelegators,
 		orchDelegators:             &delegatorsResponse{},
 	}
 
@@ -142,11 +361,16 @@ func NewOrchDelegatorsExporter(orchAddress string, fetchInterval time.Duration,
 		"X-Device-ID": {fmt.Sprintf(constants.ClientIDTemplate, orchAddress)},
 	}
 
-	// Initialize fetcher.
+	// Initialize fetchers.
 	exporter.orchDelegatorsFetcher = fetcher.Fetcher{
 		URL:     exporter.orchDelegatorsEndpoint,
-		Data:    &exporter.orchDelegators,
 		Headers: headers,
+		Client:  client,
+	}
+	exporter.delegatorsPageFetcher = fetcher.Fetcher{
+		URL:     exporter.orchDelegatorsEndpoint,
+		Headers: headers,
+		Client:  client,
 	}
 
 	// Initialize metrics.
@@ -156,33 +380,88 @@ func NewOrchDelegatorsExporter(orchAddress string, fetchInterval time.Duration,
 	return exporter
 }
 
-// Start starts the OrchDelegatorsExporter.
-func (m *OrchDelegatorsExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchDelegatorsFetcher.FetchGraphQLData(m.orchDelegatorsGraphqlQuery)
-	m.updateMetrics()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+// NOTE: Nothing is published when any of the delegator pages fails to fetch, so that an incomplete
+// delegator set is not counted as churn.
+func (m *OrchDelegatorsExporter) fetchData() bool {
+	response := &delegatorsResponse{}
+	m.orchDelegatorsFetcher.Data = response
+	if err := m.orchDelegatorsFetcher.FetchGraphQLData(m.orchDelegatorsGraphqlQuery); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator delegators data: %v", exporterName, err)
+		return false
+	}
+	if !m.fetchRemainingDelegators(response) {
+		return false
+	}
 
-	// Start fetcher in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
+	m.countChurn(response.Data.Delegators)
 
-		for range ticker.C {
-			m.orchDelegators.Mutex.Lock()
-			m.orchDelegatorsFetcher.FetchGraphQLData(m.orchDelegatorsGraphqlQuery)
-			m.orchDelegators.Mutex.Unlock()
+	m.mu.Lock()
+	m.orchDelegators = response
+	m.mu.Unlock()
+	return true
+}
+
+// fetchRemainingDelegators pages through the delegators after the first page and appends them to
+// the response. It reports whether all pages were fetched.
+func (m *OrchDelegatorsExporter) fetchRemainingDelegators(response *delegatorsResponse) bool {
+	page := response.Data.Delegators
+	for len(page) == delegatorsPageSize {
+		pageResponse := &delegatorsPageResponse{}
+		m.delegatorsPageFetcher.Data = pageResponse
+		query := fmt.Sprintf(delegatorsPageQueryTemplate, m.orchAddress, page[len(page)-1].ID, delegatorsPageSize)
+		if err := m.delegatorsPageFetcher.FetchGraphQLData(query); err != nil {
+			log.Printf("%s exporter: error fetching orchestrator delegators page data: %v", exporterName, err)
+			return false
 		}
-	}()
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+		page = pageResponse.Data.Delegators
+		response.Data.Delegators = append(response.Data.Delegators, page...)
+	}
+	return true
+}
+
+// countChurn counts the delegators that joined or left since the previous fetch. The first fetch
+// only establishes the baseline.
+func (m *OrchDelegatorsExporter) countChurn(delegators []delegator) {
+	current := make(map[string]bool, len(delegators))
+	for _, delegator := range delegators {
+		current[delegator.ID] = true
+	}
 
-		for range ticker.C {
-			m.orchDelegators.Mutex.Lock()
-			m.updateMetrics()
-			m.orchDelegators.Mutex.Unlock()
+	if m.prevDelegators != nil {
+		for id := range current {
+			if !m.prevDelegators[id] {
+				m.Joined.Inc()
+			}
+		}
+		for id := range m.prevDelegators {
+			if !current[id] {
+				m.Left.Inc()
+			}
 		}
-	}()
+	}
+	m.prevDelegators = current
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchDelegatorsExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchDelegatorsExporter in logs and metrics.
+func (m *OrchDelegatorsExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchDelegatorsExporter's metrics are registered with.
+func (m *OrchDelegatorsExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchDelegatorsExporter and blocks until ctx is cancelled.
+func (m *OrchDelegatorsExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
@@ -0,0 +1,113 @@
+package orch_delegators_exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"livepeer-exporter/testutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestExporter returns an OrchDelegatorsExporter that fetches from server.
+func newTestExporter(server *testutil.Server) *OrchDelegatorsExporter {
+	return NewOrchDelegatorsExporter(testutil.OrchAddress, time.Minute, time.Minute, 0, nil, server.URL, server.Client())
+}
+
+func TestStakeShareUsesTotalStake(t *testing.T) {
+	// NOTE: The total stake exceeds the sum of the fetched delegators, as happens when not all
+	// delegators were returned.
+	body := strings.Replace(string(testutil.Fixture(t, "orch_delegators.json")), `"totalStake": "1000000"`, `"totalStake": "2000000"`, 1)
+	server := testutil.NewServer(t, testutil.Route{Body: body})
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	shares := map[string]float64{
+		"0x0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d": 0.25,
+		"0x847791cbf03be716a7fe9dc8c9affe17bd49ae5e": 0.12500025,
+		"0x1f2e3d4c5b6a79880f1e2d3c4b5a69788f9e0d1c": 0.12499975,
+	}
+	for id, want := range shares {
+		if got := testutil.Value(t, exporter.StakeShare.WithLabelValues(id)); got != want {
+			t.Errorf("livepeer_orch_delegator_stake_share{id=%q} = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestStakeShareSkippedWithoutTotalStake(t *testing.T) {
+	body := strings.Replace(string(testutil.Fixture(t, "orch_delegators.json")), `"totalStake": "1000000"`, `"totalStake": null`, 1)
+	server := testutil.NewServer(t, testutil.Route{Body: body})
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	if n := testutil.Count(exporter.StakeShare); n != 0 {
+		t.Errorf("livepeer_orch_delegator_stake_share has %d series, want 0", n)
+	}
+}
+
+// delegatorsPage returns a delegators response with the delegators from first up to, but excluding,
+// last. The first page also contains the orchestrator and protocol data.
+func delegatorsPage(t *testing.T, first, last int) string {
+	t.Helper()
+
+	delegators := []map[string]string{}
+	for i := first; i < last; i++ {
+		delegators = append(delegators, map[string]string{
+			"id":           fmt.Sprintf("0x%040x", i),
+			"startRound":   "3000",
+			"bondedAmount": "1",
+			"fees":         "0",
+		})
+	}
+	data := map[string]any{"delegators": delegators}
+	if first == 0 {
+		data["transcoder"] = map[string]string{"totalStake": fmt.Sprint(last)}
+		data["protocol"] = map[string]any{"currentRound": map[string]string{"id": "3302"}}
+	}
+	body, err := json.Marshal(map[string]any{"data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestFetchDelegatorsPages(t *testing.T) {
+	secondPage := testutil.Route{Contains: fmt.Sprintf(`id_gt: \"0x%040x\"`, delegatorsPageSize-1), Body: delegatorsPage(t, delegatorsPageSize, delegatorsPageSize+2)}
+	firstPage := testutil.Route{Body: delegatorsPage(t, 0, delegatorsPageSize)}
+	server := testutil.NewServer(t, secondPage, firstPage)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	if got := testutil.Value(t, exporter.DelegatorCount); got != delegatorsPageSize+2 {
+		t.Errorf("livepeer_orch_delegator_count = %v, want %v", got, delegatorsPageSize+2)
+	}
+	if hits := server.Hits("/"); hits != 2 {
+		t.Errorf("endpoint received %d requests, want 2", hits)
+	}
+
+	// A failing page must not be counted as delegators that left.
+	server.SetRoutes(t, testutil.Route{Contains: "id_gt", Status: http.StatusInternalServerError}, firstPage)
+	if exporter.fetchData() {
+		t.Fatal("fetchData() succeeded, want failure")
+	}
+	server.SetRoutes(t, secondPage, firstPage)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	if got := testutil.Value(t, exporter.Left); got != 0 {
+		t.Errorf("livepeer_orch_delegators_left_total = %v, want 0", got)
+	}
+	if got := testutil.Value(t, exporter.Joined); got != 0 {
+		t.Errorf("livepeer_orch_delegators_joined_total = %v, want 0", got)
+	}
+}
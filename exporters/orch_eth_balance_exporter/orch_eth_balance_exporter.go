@@ -0,0 +1,177 @@
+// Package orch_eth_balance_exporter implements a Livepeer orchestrator ETH balance exporter that
+// fetches the ETH balance of the orchestrator account from an Arbitrum JSON-RPC endpoint and exposes
+// whether enough ETH is left to pay the gas of ticket redemptions via Prometheus metrics. It also
+// publishes the chain head block, which is used to calculate how far the subgraph lags behind.
+package orch_eth_balance_exporter
+
+import (
+	"context"
+	"fmt"
+	"livepeer-exporter/ethrpc"
+	"livepeer-exporter/metrics"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_eth_balance"
+
+// weiPerETH is the number of Wei in one ETH.
+var weiPerETH = new(big.Float).SetFloat64(1e18)
+
+// OrchETHBalanceExporter fetches the ETH balance of the orchestrator and exposes it via Prometheus metrics.
+type OrchETHBalanceExporter struct {
+	// Metrics.
+	Balance    prometheus.Gauge
+	BalanceLow prometheus.Gauge
+	registry   *prometheus.Registry // The registry the metrics are registered with.
+
+	// Config settings.
+	orchAddress    string        // The orchestrator address to fetch the balance of.
+	fetchInterval  time.Duration // How often to fetch data.
+	updateInterval time.Duration // How often to update metrics.
+	clock          runner.Clock  // The clock that drives the fetch and update loops.
+	lowThreshold   float64       // The balance in ETH below which the balance is considered low.
+	rpcURL         string        // The JSON-RPC endpoint to fetch data from.
+	client         *http.Client  // The client to send the JSON-RPC requests with.
+
+	// Data.
+	mu      sync.RWMutex // Guards the data returned by the API.
+	balance *float64     // The ETH balance returned by the API. Nil until it was fetched.
+}
+
+// initMetrics initializes the orchestrator ETH balance metrics.
+func (m *OrchETHBalanceExporter) initMetrics() {
+	m.Balance = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_eth_balance",
+			Help: "The ETH balance of the orchestrator account that pays the gas of ticket redemptions.",
+		},
+	)
+	m.BalanceLow = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_eth_balance_low",
+			Help: "Whether the ETH balance of the orchestrator is below the configured threshold.",
+		},
+	)
+}
+
+// registerMetrics registers the orchestrator ETH balance metrics with the exporter's registry.
+func (m *OrchETHBalanceExporter) registerMetrics() {
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		m.Balance,
+		m.BalanceLow,
+	)
+}
+
+// updateMetrics updates the metrics with the data fetched from the JSON-RPC endpoint.
+// NOTE: The metrics are not updated until the balance was fetched, so that a missing balance is not
+// reported as an empty account.
+func (m *OrchETHBalanceExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.balance == nil {
+		return
+	}
+	m.Balance.Set(util.RoundTokenAmount(*m.balance))
+	m.BalanceLow.Set(util.BoolToFloat64(*m.balance < m.lowThreshold))
+}
+
+// NewOrchETHBalanceExporter creates a new OrchETHBalanceExporter. The balance is considered low when
+// it drops below lowThreshold ETH.
+func NewOrchETHBalanceExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, lowThreshold float64, rpcURL string, client *http.Client) *OrchETHBalanceExporter {
+	exporter := &OrchETHBalanceExporter{
+		orchAddress:    orchAddress,
+		fetchInterval:  fetchInterval,
+		updateInterval: updateInterval,
+		clock:          runner.RealClock,
+		lowThreshold:   lowThreshold,
+		rpcURL:         rpcURL,
+		client:         client,
+	}
+
+	// Initialize metrics.
+	exporter.initMetrics()
+	exporter.registerMetrics()
+
+	return exporter
+}
+
+// fetchBalance fetches the ETH balance of the orchestrator.
+func (m *OrchETHBalanceExporter) fetchBalance() (float64, error) {
+	result, err := ethrpc.Call(m.client, m.rpcURL, "eth_getBalance", m.orchAddress, "latest")
+	if err != nil {
+		return 0, err
+	}
+
+	wei, ok := new(big.Int).SetString(result, 0)
+	if !ok {
+		return 0, fmt.Errorf("failed to parse balance '%s'", result)
+	}
+	balance, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), weiPerETH).Float64()
+	return balance, nil
+}
+
+// fetchChainHead fetches the latest block number of the chain.
+func (m *OrchETHBalanceExporter) fetchChainHead() (int64, error) {
+	result, err := ethrpc.Call(m.client, m.rpcURL, "eth_blockNumber")
+	if err != nil {
+		return 0, err
+	}
+
+	number, ok := new(big.Int).SetString(result, 0)
+	if !ok || !number.IsInt64() {
+		return 0, fmt.Errorf("failed to parse block number '%s'", result)
+	}
+	return number.Int64(), nil
+}
+
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The chain head block is published directly. It reports whether the balance was fetched.
+func (m *OrchETHBalanceExporter) fetchData() bool {
+	if chainHead, err := m.fetchChainHead(); err != nil {
+		log.Printf("%s exporter: error fetching chain head block: %v", exporterName, err)
+	} else {
+		metrics.SetChainHeadBlock(chainHead)
+	}
+
+	balance, err := m.fetchBalance()
+	if err != nil {
+		log.Printf("%s exporter: error fetching orchestrator ETH balance: %v", exporterName, err)
+		return false
+	}
+
+	m.mu.Lock()
+	m.balance = &balance
+	m.mu.Unlock()
+	return true
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchETHBalanceExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchETHBalanceExporter in logs and metrics.
+func (m *OrchETHBalanceExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchETHBalanceExporter's metrics are registered with.
+func (m *OrchETHBalanceExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchETHBalanceExporter and blocks until ctx is cancelled.
+func (m *OrchETHBalanceExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
+}
@@ -3,29 +3,55 @@
 package orch_info_exporter
 
 import (
+	"context"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
 	"livepeer-exporter/util"
 	"log"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	orchInfoEndpoint = constants.LivePeerSubgraphEndpoint
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_info"
 
+var (
 	// Global variables to track whether a warning has already been logged for a invalid delegator address.
 	hasLoggedNoDelegator bool
+
+	// Global variable to track whether a warning has already been logged for an unregistered orchestrator address.
+	hasLoggedNotRegistered bool
 )
 
+// FeesPerStakeWindows contains the supported fee windows of the 'livepeer_orch_fees_per_stake' metric.
+var FeesPerStakeWindows = []string{"30d", "90d", "total"}
+
+// ActivationStates contains the states of the 'livepeer_orch_activation_state' metric.
+var ActivationStates = []string{"never_activated", "pending", "active", "deactivated"}
+
+// recentPools is the minimum number of most recent reward pools fetched, which covers the rounds the
+// reward call ratio is calculated over. More pools are fetched when the cut history covers more rounds.
+// NOTE: The pools are fetched newest first, since the subgraph otherwise returns the first 100 pools
+// by ID, which do not contain the recent rounds of orchestrators that were active for longer. Round
+// IDs are ordered as strings, which matches their numeric order while they have the same length.
+const recentPools = 31
+
+// MaxCutHistoryRounds is the largest number of rounds the cut history metrics may cover, which is the
+// largest number of pools the subgraph returns in one query.
+const MaxCutHistoryRounds = 1000
+
 // graphqlQuery represents the GraphQL query to fetch data from the GraphQL API.
 const graphqlQueryTemplate = `
 {
 	transcoder(id: "%s") {
+		id
 		delegator {
 			bondedAmount
 			withdrawnFees
@@ -41,8 +67,10 @@ const graphqlQueryTemplate = `
 		activationRound
 		active
 		feeShare
-		pools {
+		pools(orderBy: round__id, orderDirection: desc, first: %d) {
 			rewardTokens
+			rewardCut
+			feeShare
 			round {
 				id
 			}
@@ -54,13 +82,46 @@ const graphqlQueryTemplate = `
 		ninetyDayVolumeETH
 		thirtyDayVolumeETH
 		totalVolumeETH
-		delegators (where:{id: "%s"}){
-			bondedAmount
-		}
+	}
+	unbondingLocks(where: {delegator: "%s"}) {
+		unbondingLockId
+		amount
+		withdrawRound
+	}
+	activeTranscoders: transcoders(where: {active: true}, orderBy: totalStake, orderDirection: asc, first: 1) {
+		id
+		totalStake
+	}
+	stakeRanking: transcoders(where: {active: true}, orderBy: totalStake, orderDirection: desc, first: 1000) {
+		id
 	}
 	protocol(id: "0") {
 		currentRound {
 			id
+			startBlock
+			startTimestamp
+			length
+		}
+		inflation
+		totalSupply
+		totalActiveStake
+	}
+	_meta {
+		block {
+			number
+		}
+		hasIndexingErrors
+	}
+}
+`
+
+// secondaryGraphqlQueryTemplate represents the GraphQL query to fetch the stake the secondary
+// address delegates to the orchestrator.
+const secondaryGraphqlQueryTemplate = `
+{
+	transcoder(id: "%s") {
+		delegators(where: {id: "%s"}) {
+			bondedAmount
 		}
 	}
 }
@@ -68,46 +129,75 @@ const graphqlQueryTemplate = `
 
 // delegatingInfoResponse represents the structure of the pools field contained in the GraphQL API response.
 type pool struct {
-	RewardTokens string
+	RewardTokens util.Numeric
+	RewardCut    util.Numeric
+	FeeShare     util.Numeric
 	Round        struct {
 		ID string
 	}
 }
 
+// unbondingLock represents the structure of the unbondingLocks field contained in the GraphQL API response.
+type unbondingLock struct {
+	UnbondingLockID int
+	Amount          util.Numeric
+	WithdrawRound   string
+}
+
 // transcoderResponse represents the structure of the GraphQL API response.
 type transcoderResponse struct {
-	sync.Mutex
-
-	// Response data.
 	Data struct {
 		Transcoder struct {
+			ID        string
 			Delegator struct {
-				BondedAmount   string
-				WithdrawnFees  string
+				BondedAmount   util.Numeric
+				WithdrawnFees  util.Numeric
 				LastClaimRound struct {
 					ID string
 				}
 				StartRound string
 			}
-			TotalStake      string
+			TotalStake      util.Numeric
 			LastRewardRound struct {
 				ID string
 			}
 			ActivationRound    string
 			Active             bool
-			FeeShare           string
+			FeeShare           util.Numeric
 			Pools              []pool
-			RewardCut          string
-			NinetyDayVolumeETH string
-			ThirtyDayVolumeETH string
-			TotalVolumeETH     string
-			Delegators         []struct {
-				BondedAmount string
-			}
+			RewardCut          util.Numeric
+			NinetyDayVolumeETH util.Numeric
+			ThirtyDayVolumeETH util.Numeric
+			TotalVolumeETH     util.Numeric
+		}
+		UnbondingLocks    []unbondingLock
+		ActiveTranscoders []struct {
+			ID         string
+			TotalStake util.Numeric
+		}
+		StakeRanking []struct {
+			ID string
 		}
 		Protocol struct {
 			CurrentRound struct {
-				ID string
+				ID             string
+				StartBlock     string
+				StartTimestamp string
+				Length         string
+			}
+			Inflation        util.Numeric
+			TotalSupply      util.Numeric
+			TotalActiveStake util.Numeric
+		}
+	}
+}
+
+// secondaryResponse represents the structure of the secondary address GraphQL API response.
+type secondaryResponse struct {
+	Data struct {
+		Transcoder struct {
+			Delegators []struct {
+				BondedAmount util.Numeric
 			}
 		}
 	}
@@ -115,22 +205,73 @@ type transcoderResponse struct {
 
 // orchInfo represents the parsed data from the the Livepeer subgraph GraphQL API.
 type orchInfo struct {
-	BondedAmount       float64
-	TotalStake         float64
-	LastClaimRound     float64
-	StartRound         float64
-	WithdrawnFees      float64
-	CurrentRound       float64
-	ActivationRound    float64
-	Active             float64
-	FeeCut             float64
-	RewardCut          float64
-	LastRewardRound    float64
-	NinetyDayVolumeETH float64
-	ThirtyDayVolumeETH float64
-	TotalVolumeETH     float64
-	OrchStake          float64
-	RewardCallRatio    float64
+	Registered                  float64
+	BondedAmount                float64
+	TotalStake                  float64
+	LastClaimRound              float64
+	StartRound                  float64
+	WithdrawnFees               float64
+	CurrentRound                float64
+	ActivationRound             float64
+	Active                      float64
+	FeeCut                      float64
+	RewardCut                   float64
+	LastRewardRound             float64
+	NinetyDayVolumeETH          float64
+	ThirtyDayVolumeETH          float64
+	TotalVolumeETH              float64
+	OrchStake                   float64
+	SecondaryFetchFailed        float64
+	RewardCallRatio             float64
+	RewardCallDeadline          float64
+	RewardCalled                float64
+	DelegatorRewardShare        float64
+	ProjectedRoundRewards       float64
+	UnbondingLocks              float64
+	UnbondingAmount             float64
+	NextWithdrawRound           float64
+	StakeAboveCutoff            float64
+	FeesPerStake                float64
+	StakeChangePerHour          float64
+	StakeRank                   float64
+	StakeRankChange             float64
+	ActiveRoundsTotal           float64
+	ProtocolTotalBonded         float64
+	ProtocolTotalSupply         float64
+	ActivationState             string
+	UnbondingLockAmounts        map[string]float64
+	UnbondingLockWithdrawRounds map[string]float64
+	RewardCutsByRound           map[string]float64
+	FeeCutsByRound              map[string]float64
+}
+
+// getProjectedRoundRewards returns the LPT rewards the orchestrator earns when it calls reward in the
+// next round. Each round, the inflation rate times the total supply is minted and shared by the active
+// orchestrators in proportion to their total stake. The orchestrator keeps the reward cut of its share
+// and earns the rest in proportion to its own stake. The current inflation rate is used, although it
+// is adjusted each round towards the target bonding rate. Inactive orchestrators earn no rewards.
+func getProjectedRoundRewards(active bool, inflation, totalSupply, totalActiveStake, totalStake, orchStake, rewardCut float64) float64 {
+	if !active || totalActiveStake <= 0 || totalStake <= 0 {
+		return 0
+	}
+	poolRewards := inflation * totalSupply * totalStake / totalActiveStake
+	return poolRewards*rewardCut + poolRewards*(1-rewardCut)*orchStake/totalStake
+}
+
+// getActivationState returns the activation state of the orchestrator, which is one of the
+// ActivationStates. An orchestrator that was registered but whose activation round has not been
+// reached yet is pending.
+func getActivationState(active bool, activationRound, currentRound float64) string {
+	switch {
+	case active:
+		return "active"
+	case activationRound == 0:
+		return "never_activated"
+	case activationRound > currentRound:
+		return "pending"
+	default:
+		return "deactivated"
+	}
 }
 
 // getRewardCallRatio calculates the ratio of rounds in the last 30 days that the orchestrator claimed rewards.
@@ -163,43 +304,115 @@ func getRewardCallRatio(pools []pool, currentRound, activationRound int) float64
 	return float64(rewardedRounds) / float64(totalRounds)
 }
 
+// getRewardCallDeadline estimates the number of seconds until the orchestrator has to call reward.
+// The current block is estimated from the round start timestamp and the block time. When reward was
+// already called in the current round, the deadline is the end of the next round.
+func getRewardCallDeadline(roundStartBlock, roundStartTimestamp, roundLength float64, blockTime time.Duration, rewardCalled bool, now time.Time) float64 {
+	blockSeconds := blockTime.Seconds()
+	currentBlock := roundStartBlock + (float64(now.Unix())-roundStartTimestamp)/blockSeconds
+	remainingBlocks := roundStartBlock + roundLength - currentBlock
+	if rewardCalled {
+		remainingBlocks += roundLength
+	}
+
+	// The round can run over when it is not initialized on time.
+	if remainingBlocks < 0 {
+		return 0
+	}
+	return remainingBlocks * blockSeconds
+}
+
+// getRoundRewardTokens returns the reward tokens minted in the reward pool of the given round.
+// It returns false when the orchestrator has no pool for that round.
+func getRoundRewardTokens(pools []pool, round string) (float64, bool) {
+	for _, pool := range pools {
+		if pool.Round.ID == round {
+			rewardTokens, err := util.StringToFloat64(pool.RewardTokens)
+			return util.RoundTokenAmount(rewardTokens), err == nil
+		}
+	}
+	return 0, false
+}
+
 // OrchInfoExporter fetches data from the API and exposes orchestrator info via Prometheus.
 type OrchInfoExporter struct {
 	// Metrics.
-	BondedAmount       prometheus.Gauge
-	TotalStake         prometheus.Gauge
-	LastClaimRound     prometheus.Gauge
-	StartRound         prometheus.Gauge
-	WithdrawnFees      prometheus.Gauge
-	CurrentRound       prometheus.Gauge
-	ActivationRound    prometheus.Gauge
-	Active             prometheus.Gauge
-	FeeCut             prometheus.Gauge
-	RewardCut          prometheus.Gauge
-	LastRewardRound    prometheus.Gauge
-	NinetyDayVolumeETH prometheus.Gauge
-	ThirtyDayVolumeETH prometheus.Gauge
-	TotalVolumeETH     prometheus.Gauge
-	OrchStake          prometheus.Gauge
-	RewardCallRatio    prometheus.Gauge
+	Registered                 prometheus.Gauge
+	BondedAmount               prometheus.Gauge
+	TotalStake                 prometheus.Gauge
+	LastClaimRound             prometheus.Gauge
+	StartRound                 prometheus.Gauge
+	WithdrawnFees              prometheus.Gauge
+	CurrentRound               prometheus.Gauge
+	ActivationRound            prometheus.Gauge
+	Active                     prometheus.Gauge
+	FeeCut                     prometheus.Gauge
+	RewardCut                  prometheus.Gauge
+	LastRewardRound            prometheus.Gauge
+	NinetyDayVolumeETH         prometheus.Gauge
+	ThirtyDayVolumeETH         prometheus.Gauge
+	TotalVolumeETH             prometheus.Gauge
+	OrchStake                  prometheus.Gauge
+	SecondaryFetchFailed       prometheus.Gauge
+	RewardCallRatio            prometheus.Gauge
+	RewardCallDeadline         prometheus.Gauge
+	RewardCalled               prometheus.Gauge
+	DelegatorRewardShare       prometheus.Gauge
+	ProjectedRoundRewards      prometheus.Gauge
+	UnbondingLocks             prometheus.Gauge
+	UnbondingAmount            prometheus.Gauge
+	NextWithdrawRound          prometheus.Gauge
+	StakeAboveCutoff           prometheus.Gauge
+	FeesPerStake               prometheus.Gauge
+	StakeChangePerHour         prometheus.Gauge
+	StakeRank                  prometheus.Gauge
+	StakeRankChange            prometheus.Gauge
+	ActiveRoundsTotal          prometheus.Gauge
+	ProtocolTotalBonded        prometheus.Gauge
+	ProtocolTotalSupply        prometheus.Gauge
+	ActivationState            *prometheus.GaugeVec
+	UnbondingLockAmount        *prometheus.GaugeVec
+	UnbondingLockWithdrawRound *prometheus.GaugeVec
+	RewardCutByRound           *prometheus.GaugeVec
+	FeeCutByRound              *prometheus.GaugeVec
+	registry                   *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
+	orchAddress          string        // The orchestrator address.
 	fetchInterval        time.Duration // How often to fetch data.
 	updateInterval       time.Duration // How often to update metrics.
+	clock                runner.Clock  // The clock that drives the fetch and update loops.
+	blockTime            time.Duration // The average block time used to estimate round progress.
+	feesPerStakeWindow   string        // The fee window used for the fees per stake metric.
+	cutHistoryRounds     int           // The number of past rounds exposed in the cut history metrics.
 	orchAddressSecondary string        // The secondary orchestrator address.
 	orchInfoEndpoint     string        // The endpoint to fetch data from.
 	orchInfoGraphqlQuery string        // The GraphQL query to fetch data from the GraphQL API.
+	secondaryQuery       string        // The GraphQL query to fetch the secondary address stake.
 
 	// Data.
+	mu                 sync.RWMutex        // Guards the data returned by the API and the state parseMetrics derives from it.
 	transcoderResponse *transcoderResponse // The data returned by the API.
+	fetchedAt          time.Time           // When the data was fetched.
+	secondaryStake     *secondaryResponse  // The secondary address stake returned by the API. Nil if the last fetch failed.
 	orchInfo           *orchInfo           // The data returned by the orchestrator API, parsed into a struct.
+	prevFetchedAt      time.Time           // When the data last added to the stake window was fetched.
+	stakeWindow        *util.RollingWindow // The total stake of the fetches in the trend window.
+	rankWindow         *util.RollingWindow // The stake rank of the fetches in the trend window.
 
 	// Fetchers.
-	orchInfoFetcher fetcher.Fetcher
+	orchInfoFetcher  fetcher.Fetcher
+	secondaryFetcher fetcher.Fetcher
 }
 
 // initMetrics initializes the orchestrator info metrics.
 func (m *OrchInfoExporter) initMetrics() {
+	m.Registered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_registered",
+			Help: "Whether the subgraph has a transcoder entity for the orchestrator address.",
+		},
+	)
 	m.BondedAmount = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "livepeer_orch_bonded_amount",
@@ -290,17 +503,150 @@ func (m *OrchInfoExporter) initMetrics() {
 			Help: "The stake personally contributed by the orchestrator.",
 		},
 	)
+	m.SecondaryFetchFailed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_secondary_fetch_failed",
+			Help: "Whether the last fetch of the secondary address stake failed, in which case the orchestrator stake only includes the primary stake.",
+		},
+	)
 	m.RewardCallRatio = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "livepeer_orch_thirty_day_reward_claim_ratio",
 			Help: "How often an orchestrator claimed rewards in the last thirty rounds.",
 		},
 	)
+	m.RewardCallDeadline = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_reward_call_deadline_seconds",
+			Help: "The estimated number of seconds until the orchestrator has to call reward.",
+		},
+	)
+	m.RewardCalled = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_reward_called_current_round",
+			Help: "Whether the orchestrator already called reward in the current round.",
+		},
+	)
+	m.DelegatorRewardShare = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_delegator_reward_share",
+			Help: "The LPT a delegator earned per staked LPT in the last round the orchestrator called reward.",
+		},
+	)
+	m.ProjectedRoundRewards = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_projected_round_rewards",
+			Help: "The projected LPT rewards the orchestrator earns on its own stake and reward cut when it calls reward next round.",
+		},
+	)
+	m.UnbondingLocks = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_unbonding_locks_total",
+			Help: "The number of pending unbonding locks of the orchestrator.",
+		},
+	)
+	m.UnbondingAmount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_unbonding_amount",
+			Help: "The total amount of LPT locked in the pending unbonding locks of the orchestrator.",
+		},
+	)
+	m.NextWithdrawRound = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_next_withdraw_round",
+			Help: "The earliest round in which a pending unbonding lock of the orchestrator can be withdrawn.",
+		},
+	)
+	m.StakeAboveCutoff = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_stake_above_cutoff",
+			Help: "The total stake of the orchestrator minus the total stake of the lowest staked orchestrator in the active set.",
+		},
+	)
+	m.FeesPerStake = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_fees_per_stake",
+			Help: "The ETH fees earned by the orchestrator in the configured window per LPT of total stake.",
+		},
+	)
+	m.StakeChangePerHour = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_stake_change_per_hour",
+			Help: "The rate at which the total stake of the orchestrator changed over the trend window in LPT per hour.",
+		},
+	)
+	m.StakeRank = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_stake_rank",
+			Help: "The position of the orchestrator in the active set ordered by total stake, starting at 1. Zero when it is not active.",
+		},
+	)
+	m.StakeRankChange = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_stake_rank_change",
+			Help: "The change of the stake rank of the orchestrator over the trend window. Negative when it climbed.",
+		},
+	)
+	m.ActiveRoundsTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_active_rounds_total",
+			Help: "The number of rounds the orchestrator has been an active transcoder since its activation round. Zero when it is not active.",
+		},
+	)
+	m.ProtocolTotalBonded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_protocol_total_bonded",
+			Help: "The total LPT bonded to the active orchestrators in the protocol.",
+		},
+	)
+	m.ProtocolTotalSupply = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_protocol_total_supply",
+			Help: "The total supply of LPT.",
+		},
+	)
+	m.ActivationState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_activation_state",
+			Help: "Whether the orchestrator is in the given activation state ('never_activated', 'pending', 'active' or 'deactivated').",
+		},
+		[]string{"state"},
+	)
+	m.UnbondingLockAmount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_unbonding_lock_amount",
+			Help: "The amount of LPT locked in each pending unbonding lock.",
+		},
+		[]string{"id"},
+	)
+	m.UnbondingLockWithdrawRound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_unbonding_lock_withdraw_round",
+			Help: "The round in which each pending unbonding lock can be withdrawn.",
+		},
+		[]string{"id"},
+	)
+	m.RewardCutByRound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_reward_cut_by_round",
+			Help: "The proportion of the block reward the orchestrator took in each of the last rounds it has a reward pool for.",
+		},
+		[]string{"round"},
+	)
+	m.FeeCutByRound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_fee_cut_by_round",
+			Help: "The proportion of the fees the orchestrator took in each of the last rounds it has a reward pool for.",
+		},
+		[]string{"round"},
+	)
 }
 
-// registerMetrics registers the orchestrator info metrics with Prometheus.
+// registerMetrics registers the orchestrator info metrics with the exporter's registry.
 func (m *OrchInfoExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		m.Registered,
 		m.BondedAmount,
 		m.TotalStake,
 		m.LastClaimRound,
@@ -316,27 +662,86 @@ func (m *OrchInfoExporter) registerMetrics() {
 		m.ThirtyDayVolumeETH,
 		m.TotalVolumeETH,
 		m.OrchStake,
+		m.SecondaryFetchFailed,
 		m.RewardCallRatio,
+		m.RewardCallDeadline,
+		m.RewardCalled,
+		m.DelegatorRewardShare,
+		m.ProjectedRoundRewards,
+		m.UnbondingLocks,
+		m.UnbondingAmount,
+		m.NextWithdrawRound,
+		m.StakeAboveCutoff,
+		m.FeesPerStake,
+		m.StakeChangePerHour,
+		m.StakeRank,
+		m.StakeRankChange,
+		m.ActiveRoundsTotal,
+		m.ProtocolTotalBonded,
+		m.ProtocolTotalSupply,
+		m.ActivationState,
+		m.UnbondingLockAmount,
+		m.UnbondingLockWithdrawRound,
+		m.RewardCutByRound,
+		m.FeeCutByRound,
 	)
 }
 
 // parseMetrics parses the values from the transcoderResponse and delegatingInfoResponse and populates the orchInfo struct.
+// NOTE: The write lock is held, since the orchInfo struct and the trend windows are written as well.
 func (m *OrchInfoExporter) parseMetrics() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check whether the address is a registered orchestrator.
+	// NOTE: All other metrics read zero when it is not, which usually means the address is wrong. The
+	// current round is only empty when no data was fetched yet.
+	fetched := m.transcoderResponse.Data.Protocol.CurrentRound.ID != ""
+	registered := m.transcoderResponse.Data.Transcoder.ID != ""
+	m.orchInfo.Registered = util.BoolToFloat64(registered)
+	if fetched && !registered && !hasLoggedNotRegistered {
+		log.Printf("WARNING: No orchestrator found in the subgraph for address '%s'. Please check LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS", m.orchAddress)
+		hasLoggedNotRegistered = true
+	}
+
 	// Parse and set the orchestrator info.
-	util.SetFloatFromStr(&m.orchInfo.BondedAmount, m.transcoderResponse.Data.Transcoder.Delegator.BondedAmount)
-	util.SetFloatFromStr(&m.orchInfo.TotalStake, m.transcoderResponse.Data.Transcoder.TotalStake)
+	util.SetTokenAmountFromStr(&m.orchInfo.BondedAmount, m.transcoderResponse.Data.Transcoder.Delegator.BondedAmount)
+	util.SetTokenAmountFromStr(&m.orchInfo.TotalStake, m.transcoderResponse.Data.Transcoder.TotalStake)
 	util.SetFloatFromStr(&m.orchInfo.LastClaimRound, m.transcoderResponse.Data.Transcoder.Delegator.LastClaimRound.ID)
 	util.SetFloatFromStr(&m.orchInfo.StartRound, m.transcoderResponse.Data.Transcoder.Delegator.StartRound)
-	util.SetFloatFromStr(&m.orchInfo.WithdrawnFees, m.transcoderResponse.Data.Transcoder.Delegator.WithdrawnFees)
+	util.SetTokenAmountFromStr(&m.orchInfo.WithdrawnFees, m.transcoderResponse.Data.Transcoder.Delegator.WithdrawnFees)
 	util.SetFloatFromStr(&m.orchInfo.CurrentRound, m.transcoderResponse.Data.Protocol.CurrentRound.ID)
 	util.SetFloatFromStr(&m.orchInfo.ActivationRound, m.transcoderResponse.Data.Transcoder.ActivationRound)
 	m.orchInfo.Active = util.BoolToFloat64(m.transcoderResponse.Data.Transcoder.Active)
 	util.SetFloatFromStr(&m.orchInfo.LastRewardRound, m.transcoderResponse.Data.Transcoder.LastRewardRound.ID)
-	util.SetFloatFromStr(&m.orchInfo.NinetyDayVolumeETH, m.transcoderResponse.Data.Transcoder.NinetyDayVolumeETH)
-	util.SetFloatFromStr(&m.orchInfo.ThirtyDayVolumeETH, m.transcoderResponse.Data.Transcoder.ThirtyDayVolumeETH)
-	util.SetFloatFromStr(&m.orchInfo.TotalVolumeETH, m.transcoderResponse.Data.Transcoder.TotalVolumeETH)
+	util.SetTokenAmountFromStr(&m.orchInfo.NinetyDayVolumeETH, m.transcoderResponse.Data.Transcoder.NinetyDayVolumeETH)
+	util.SetTokenAmountFromStr(&m.orchInfo.ThirtyDayVolumeETH, m.transcoderResponse.Data.Transcoder.ThirtyDayVolumeETH)
+	util.SetTokenAmountFromStr(&m.orchInfo.TotalVolumeETH, m.transcoderResponse.Data.Transcoder.TotalVolumeETH)
 	m.orchInfo.RewardCallRatio = getRewardCallRatio(m.transcoderResponse.Data.Transcoder.Pools, int(m.orchInfo.CurrentRound), int(m.orchInfo.ActivationRound))
 
+	// Calculate and set the activation state and the number of active rounds.
+	// NOTE: The active rounds are only counted while the orchestrator is active, so that the tenure
+	// restarts when it is reactivated. The state is left empty until data was fetched.
+	m.orchInfo.ActivationState = ""
+	m.orchInfo.ActiveRoundsTotal = 0
+	if registered {
+		m.orchInfo.ActivationState = getActivationState(m.transcoderResponse.Data.Transcoder.Active, m.orchInfo.ActivationRound, m.orchInfo.CurrentRound)
+	} else if fetched {
+		m.orchInfo.ActivationState = "never_activated"
+	}
+	if m.orchInfo.ActivationState == "active" {
+		m.orchInfo.ActiveRoundsTotal = max(m.orchInfo.CurrentRound-m.orchInfo.ActivationRound, 0)
+	}
+
+	// Calculate and set the reward call deadline.
+	var roundStartBlock, roundStartTimestamp, roundLength float64
+	util.SetFloatFromStr(&roundStartBlock, m.transcoderResponse.Data.Protocol.CurrentRound.StartBlock)
+	util.SetFloatFromStr(&roundStartTimestamp, m.transcoderResponse.Data.Protocol.CurrentRound.StartTimestamp)
+	util.SetFloatFromStr(&roundLength, m.transcoderResponse.Data.Protocol.CurrentRound.Length)
+	rewardCalled := m.orchInfo.LastRewardRound == m.orchInfo.CurrentRound
+	m.orchInfo.RewardCalled = util.BoolToFloat64(rewardCalled)
+	m.orchInfo.RewardCallDeadline = getRewardCallDeadline(roundStartBlock, roundStartTimestamp, roundLength, m.blockTime, rewardCalled, m.clock.Now())
+
 	// Calculate and set reward and fee cut proportions.
 	feeShare, err := util.StringToFloat64(m.transcoderResponse.Data.Transcoder.FeeShare)
 	if err != nil {
@@ -351,13 +756,109 @@ func (m *OrchInfoExporter) parseMetrics() {
 		m.orchInfo.RewardCut = util.Round(rewardCut*1e-6, 2)
 	}
 
+	// Calculate and set the delegator reward share.
+	// NOTE: Skipped when the total stake is zero or the reward pool of the last reward round is unknown.
+	rewardTokens, ok := getRoundRewardTokens(m.transcoderResponse.Data.Transcoder.Pools, m.transcoderResponse.Data.Transcoder.LastRewardRound.ID)
+	if ok && err == nil && m.orchInfo.TotalStake > 0 {
+		m.orchInfo.DelegatorRewardShare = rewardTokens * (1 - rewardCut*1e-6) / m.orchInfo.TotalStake
+	}
+
+	// Calculate and set the pending unbonding locks.
+	m.orchInfo.UnbondingLocks = float64(len(m.transcoderResponse.Data.UnbondingLocks))
+	m.orchInfo.UnbondingAmount = 0
+	m.orchInfo.NextWithdrawRound = 0
+	m.orchInfo.UnbondingLockAmounts = make(map[string]float64)
+	m.orchInfo.UnbondingLockWithdrawRounds = make(map[string]float64)
+	for _, lock := range m.transcoderResponse.Data.UnbondingLocks {
+		var amount, withdrawRound float64
+		util.SetTokenAmountFromStr(&amount, lock.Amount)
+		util.SetFloatFromStr(&withdrawRound, lock.WithdrawRound)
+
+		id := strconv.Itoa(lock.UnbondingLockID)
+		m.orchInfo.UnbondingLockAmounts[id] = amount
+		m.orchInfo.UnbondingLockWithdrawRounds[id] = withdrawRound
+		m.orchInfo.UnbondingAmount += amount
+		if m.orchInfo.NextWithdrawRound == 0 || withdrawRound < m.orchInfo.NextWithdrawRound {
+			m.orchInfo.NextWithdrawRound = withdrawRound
+		}
+	}
+
+	// Calculate and set the reward and fee cut history.
+	// NOTE: Only the pools of the last cutHistoryRounds rounds are exposed to bound the cardinality.
+	m.orchInfo.RewardCutsByRound = make(map[string]float64)
+	m.orchInfo.FeeCutsByRound = make(map[string]float64)
+	for _, pool := range m.transcoderResponse.Data.Transcoder.Pools {
+		round, err := strconv.Atoi(pool.Round.ID)
+		if err != nil || round <= int(m.orchInfo.CurrentRound)-m.cutHistoryRounds {
+			continue
+		}
+		if poolRewardCut, err := util.StringToFloat64(pool.RewardCut); err == nil {
+			m.orchInfo.RewardCutsByRound[pool.Round.ID] = util.Round(poolRewardCut*1e-6, 2)
+		}
+		if poolFeeShare, err := util.StringToFloat64(pool.FeeShare); err == nil {
+			m.orchInfo.FeeCutsByRound[pool.Round.ID] = util.Round(1-poolFeeShare*1e-6, 2)
+		}
+	}
+
+	// Calculate and set the fees per stake.
+	// NOTE: Skipped when the total stake is zero.
+	if m.orchInfo.TotalStake > 0 {
+		var fees float64
+		switch m.feesPerStakeWindow {
+		case "90d":
+			fees = m.orchInfo.NinetyDayVolumeETH
+		case "total":
+			fees = m.orchInfo.TotalVolumeETH
+		default:
+			fees = m.orchInfo.ThirtyDayVolumeETH
+		}
+		m.orchInfo.FeesPerStake = fees / m.orchInfo.TotalStake
+	}
+
+	// Calculate and set the stake rank.
+	// NOTE: The rank is zero when the orchestrator is not in the active set.
+	m.orchInfo.StakeRank = 0
+	for i, transcoder := range m.transcoderResponse.Data.StakeRanking {
+		if strings.EqualFold(transcoder.ID, m.orchAddress) {
+			m.orchInfo.StakeRank = float64(i + 1)
+			break
+		}
+	}
+
+	// Calculate and set the stake change rate and the stake rank change.
+	// NOTE: Each fetch is only added once, so that the rate is independent of the fetch and update
+	// intervals. It stays zero until two fetches succeeded after a restart.
+	if fetched && m.fetchedAt.After(m.prevFetchedAt) {
+		m.stakeWindow.Add(m.fetchedAt, m.orchInfo.TotalStake)
+		if rate, ok := m.stakeWindow.RatePerHour(); ok {
+			m.orchInfo.StakeChangePerHour = rate
+		}
+		if m.orchInfo.StakeRank > 0 {
+			m.rankWindow.Add(m.fetchedAt, m.orchInfo.StakeRank)
+			if change, ok := m.rankWindow.Change(); ok {
+				m.orchInfo.StakeRankChange = change
+			}
+		}
+		m.prevFetchedAt = m.fetchedAt
+	}
+
+	// Calculate and set the stake above the active set cutoff.
+	// NOTE: A negative value means the orchestrator has too little stake to be in the active set.
+	if len(m.transcoderResponse.Data.ActiveTranscoders) > 0 {
+		var cutoffStake float64
+		util.SetTokenAmountFromStr(&cutoffStake, m.transcoderResponse.Data.ActiveTranscoders[0].TotalStake)
+		m.orchInfo.StakeAboveCutoff = m.orchInfo.TotalStake - cutoffStake
+	}
+
 	// Calculate and set the orchestrator stake.
 	// NOTE: If the orchestrator has a secondary address, we need to add the stake from the secondary address to the stake from the primary address.
-	util.SetFloatFromStr(&m.orchInfo.OrchStake, m.transcoderResponse.Data.Transcoder.Delegator.BondedAmount)
-	if m.orchAddressSecondary != "" {
+	// Only the primary stake is used when the secondary stake could not be fetched.
+	util.SetTokenAmountFromStr(&m.orchInfo.OrchStake, m.transcoderResponse.Data.Transcoder.Delegator.BondedAmount)
+	m.orchInfo.SecondaryFetchFailed = util.BoolToFloat64(fetched && m.orchAddressSecondary != "" && m.secondaryStake == nil)
+	if m.orchAddressSecondary != "" && m.secondaryStake != nil {
 		var secondaryStake float64
-		if len(m.transcoderResponse.Data.Transcoder.Delegators) > 0 {
-			util.SetFloatFromStr(&secondaryStake, m.transcoderResponse.Data.Transcoder.Delegators[0].BondedAmount)
+		if delegators := m.secondaryStake.Data.Transcoder.Delegators; len(delegators) > 0 {
+			util.SetTokenAmountFromStr(&secondaryStake, delegators[0].BondedAmount)
 		} else {
 			secondaryStake = 0
 			if !hasLoggedNoDelegator {
@@ -367,16 +868,32 @@ func (m *OrchInfoExporter) parseMetrics() {
 		}
 		m.orchInfo.OrchStake += secondaryStake
 	}
+
+	// Set the protocol totals.
+	util.SetTokenAmountFromStr(&m.orchInfo.ProtocolTotalBonded, m.transcoderResponse.Data.Protocol.TotalActiveStake)
+	util.SetTokenAmountFromStr(&m.orchInfo.ProtocolTotalSupply, m.transcoderResponse.Data.Protocol.TotalSupply)
+
+	// Calculate and set the projected round rewards.
+	// NOTE: Skipped when the protocol inflation, total supply or total active stake are unavailable.
+	inflation, inflationErr := m.transcoderResponse.Data.Protocol.Inflation.Float64()
+	totalSupply, totalSupplyErr := m.transcoderResponse.Data.Protocol.TotalSupply.Float64()
+	totalActiveStake, totalActiveStakeErr := m.transcoderResponse.Data.Protocol.TotalActiveStake.Float64()
+	rewardCut, rewardCutErr := m.transcoderResponse.Data.Transcoder.RewardCut.Float64()
+	if inflationErr == nil && totalSupplyErr == nil && totalActiveStakeErr == nil && rewardCutErr == nil {
+		m.orchInfo.ProjectedRoundRewards = util.RoundTokenAmount(getProjectedRoundRewards(
+			m.transcoderResponse.Data.Transcoder.Active, inflation*1e-9, totalSupply, totalActiveStake,
+			m.orchInfo.TotalStake, m.orchInfo.OrchStake, rewardCut*1e-6,
+		))
+	}
 }
 
 // updateMetrics updates the metrics with the data fetched from the Livepeer subgraph GraphQL API.
 func (m *OrchInfoExporter) updateMetrics() {
 	// Parse the metrics from the response data.
-	m.transcoderResponse.Mutex.Lock()
 	m.parseMetrics()
-	m.transcoderResponse.Mutex.Unlock()
 
 	// Set the metrics.
+	m.Registered.Set(m.orchInfo.Registered)
 	m.BondedAmount.Set(m.orchInfo.BondedAmount)
 	m.TotalStake.Set(m.orchInfo.TotalStake)
 	m.LastClaimRound.Set(m.orchInfo.LastClaimRound)
@@ -392,19 +909,68 @@ func (m *OrchInfoExporter) updateMetrics() {
 	m.ThirtyDayVolumeETH.Set(m.orchInfo.ThirtyDayVolumeETH)
 	m.TotalVolumeETH.Set(m.orchInfo.TotalVolumeETH)
 	m.OrchStake.Set(m.orchInfo.OrchStake)
+	m.SecondaryFetchFailed.Set(m.orchInfo.SecondaryFetchFailed)
 	m.RewardCallRatio.Set(m.orchInfo.RewardCallRatio)
+	m.RewardCallDeadline.Set(m.orchInfo.RewardCallDeadline)
+	m.RewardCalled.Set(m.orchInfo.RewardCalled)
+	m.DelegatorRewardShare.Set(m.orchInfo.DelegatorRewardShare)
+	m.ProjectedRoundRewards.Set(m.orchInfo.ProjectedRoundRewards)
+	m.UnbondingLocks.Set(m.orchInfo.UnbondingLocks)
+	m.UnbondingAmount.Set(m.orchInfo.UnbondingAmount)
+	m.NextWithdrawRound.Set(m.orchInfo.NextWithdrawRound)
+	m.StakeAboveCutoff.Set(m.orchInfo.StakeAboveCutoff)
+	m.FeesPerStake.Set(m.orchInfo.FeesPerStake)
+	m.StakeChangePerHour.Set(m.orchInfo.StakeChangePerHour)
+	m.StakeRank.Set(m.orchInfo.StakeRank)
+	m.StakeRankChange.Set(m.orchInfo.StakeRankChange)
+	m.ActiveRoundsTotal.Set(m.orchInfo.ActiveRoundsTotal)
+	m.ProtocolTotalBonded.Set(m.orchInfo.ProtocolTotalBonded)
+	m.ProtocolTotalSupply.Set(m.orchInfo.ProtocolTotalSupply)
+	if m.orchInfo.ActivationState != "" {
+		for _, state := range ActivationStates {
+			m.ActivationState.WithLabelValues(state).Set(util.BoolToFloat64(state == m.orchInfo.ActivationState))
+		}
+	}
+
+	// Reset the unbonding lock metrics so that withdrawn locks are removed.
+	m.UnbondingLockAmount.Reset()
+	m.UnbondingLockWithdrawRound.Reset()
+	for id, amount := range m.orchInfo.UnbondingLockAmounts {
+		m.UnbondingLockAmount.WithLabelValues(id).Set(amount)
+		m.UnbondingLockWithdrawRound.WithLabelValues(id).Set(m.orchInfo.UnbondingLockWithdrawRounds[id])
+	}
+
+	// Reset the cut history metrics so that rounds outside the history window are removed.
+	m.RewardCutByRound.Reset()
+	m.FeeCutByRound.Reset()
+	for round, cut := range m.orchInfo.RewardCutsByRound {
+		m.RewardCutByRound.WithLabelValues(round).Set(cut)
+	}
+	for round, cut := range m.orchInfo.FeeCutsByRound {
+		m.FeeCutByRound.WithLabelValues(round).Set(cut)
+	}
 }
 
-// NewOrchInfoExporter creates a new OrchInfoExporter.
-func NewOrchInfoExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, orchAddrSecondary string) *OrchInfoExporter {
+// NewOrchInfoExporter creates a new OrchInfoExporter. The feesPerStakeWindow should be one of the
+// FeesPerStakeWindows, cutHistoryRounds sets how many past rounds the cut history metrics cover and
+// should not exceed MaxCutHistoryRounds and trendWindow sets the window the stake change rate and stake rank change are calculated over.
+func NewOrchInfoExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, orchAddrSecondary string, blockTime time.Duration, feesPerStakeWindow string, cutHistoryRounds int, trendWindow time.Duration, endpoint string, client *http.Client) *OrchInfoExporter {
 	exporter := &OrchInfoExporter{
+		orchAddress:          orchAddress,
 		fetchInterval:        fetchInterval,
 		updateInterval:       updateInterval,
+		clock:                runner.RealClock,
+		blockTime:            blockTime,
+		feesPerStakeWindow:   feesPerStakeWindow,
+		cutHistoryRounds:     cutHistoryRounds,
 		orchAddressSecondary: orchAddrSecondary,
-		orchInfoEndpoint:     orchInfoEndpoint,
-		orchInfoGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress, orchAddrSecondary),
+		orchInfoEndpoint:     endpoint,
+		orchInfoGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress, max(recentPools, cutHistoryRounds), orchAddress),
+		secondaryQuery:       fmt.Sprintf(secondaryGraphqlQueryTemplate, orchAddress, orchAddrSecondary),
 		transcoderResponse:   &transcoderResponse{},
 		orchInfo:             &orchInfo{},
+		stakeWindow:          util.NewRollingWindow(trendWindow),
+		rankWindow:           util.NewRollingWindow(trendWindow),
 	}
 
 	// Create request headers.
@@ -412,11 +978,16 @@ func NewOrchInfoExporter(orchAddress string, fetchInterval time.Duration, update
 		"X-Device-ID": {fmt.Sprintf(constants.ClientIDTemplate, orchAddress)},
 	}
 
-	// Initialize fetcher.
+	// Initialize fetchers.
 	exporter.orchInfoFetcher = fetcher.Fetcher{
 		URL:     exporter.orchInfoEndpoint,
-		Data:    &exporter.transcoderResponse,
 		Headers: headers,
+		Client:  client,
+	}
+	exporter.secondaryFetcher = fetcher.Fetcher{
+		URL:     exporter.orchInfoEndpoint,
+		Headers: headers,
+		Client:  client,
 	}
 
 	// Initialize metrics.
@@ -426,31 +997,61 @@ func NewOrchInfoExporter(orchAddress string, fetchInterval time.Duration, update
 	return exporter
 }
 
-// Start starts the OrchInfoExporter.
-func (m *OrchInfoExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchInfoFetcher.FetchGraphQLData(m.orchInfoGraphqlQuery)
-	m.updateMetrics()
-
-	// Start fetchers in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			m.transcoderResponse.Mutex.Lock()
-			m.orchInfoFetcher.FetchGraphQLData(m.orchInfoGraphqlQuery)
-			m.transcoderResponse.Mutex.Unlock()
-		}
-	}()
+// fetchSecondaryStake fetches the stake the secondary address delegates to the orchestrator.
+func (m *OrchInfoExporter) fetchSecondaryStake() (*secondaryResponse, error) {
+	response := &secondaryResponse{}
+	m.secondaryFetcher.Data = response
+	if err := m.secondaryFetcher.FetchGraphQLData(m.secondaryQuery); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+// NOTE: The secondary address stake is fetched independently, so that a failing secondary fetch
+// does not fail the whole info update.
+func (m *OrchInfoExporter) fetchData() bool {
+	response := &transcoderResponse{}
+	m.orchInfoFetcher.Data = response
+	if err := m.orchInfoFetcher.FetchGraphQLData(m.orchInfoGraphqlQuery); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator info data: %v", exporterName, err)
+		return false
+	}
 
-		for range ticker.C {
-			m.updateMetrics()
+	var secondaryStake *secondaryResponse
+	if m.orchAddressSecondary != "" {
+		var err error
+		if secondaryStake, err = m.fetchSecondaryStake(); err != nil {
+			log.Printf("WARNING: %s exporter: error fetching secondary address stake, exposing the primary stake only: %v", exporterName, err)
 		}
-	}()
+	}
+
+	m.mu.Lock()
+	m.transcoderResponse = response
+	m.secondaryStake = secondaryStake
+	m.fetchedAt = m.clock.Now()
+	m.mu.Unlock()
+	return true
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchInfoExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchInfoExporter in logs and metrics.
+func (m *OrchInfoExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchInfoExporter's metrics are registered with.
+func (m *OrchInfoExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchInfoExporter and blocks until ctx is cancelled.
+func (m *OrchInfoExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
@@ -0,0 +1,206 @@
+package orch_info_exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"livepeer-exporter/testutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// secondaryAddress is the secondary address used in the tests.
+const secondaryAddress = "0x0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d"
+
+// newTestExporter returns an OrchInfoExporter that fetches from server.
+func newTestExporter(server *testutil.Server, secondary string) *OrchInfoExporter {
+	return NewOrchInfoExporter(testutil.OrchAddress, time.Minute, time.Minute, secondary, 250*time.Millisecond, "total", 10, time.Hour, server.URL, server.Client())
+}
+
+// infoRoutes returns the routes that serve the orchestrator info and secondary stake fixtures.
+func infoRoutes() []testutil.Route {
+	return []testutil.Route{
+		{Contains: "delegators(where", Fixture: "orch_info_secondary.json"},
+		{Fixture: "orch_info.json"},
+	}
+}
+
+func TestFetchAndUpdateConcurrently(t *testing.T) {
+	server := testutil.NewServer(t, infoRoutes()...)
+	exporter := newTestExporter(server, secondaryAddress)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			exporter.fetchData()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			exporter.updateMetrics()
+		}
+	}()
+	wg.Wait()
+	exporter.updateMetrics()
+
+	if got := testutil.Value(t, exporter.OrchStake); got != 255000.5 {
+		t.Errorf("livepeer_orch_stake = %v, want %v", got, 255000.5)
+	}
+	if got := testutil.Value(t, exporter.CurrentRound); got != 3302 {
+		t.Errorf("livepeer_orch_current_round = %v, want %v", got, 3302)
+	}
+}
+
+func TestSecondaryFetchFailed(t *testing.T) {
+	server := testutil.NewServer(t,
+		testutil.Route{Contains: "delegators(where", Status: http.StatusInternalServerError},
+		testutil.Route{Fixture: "orch_info.json"},
+	)
+	exporter := newTestExporter(server, secondaryAddress)
+	fetchAndUpdate(t, exporter)
+
+	// Only the primary stake is exposed, while the other metrics are updated as usual.
+	if got := testutil.Value(t, exporter.SecondaryFetchFailed); got != 1 {
+		t.Errorf("livepeer_orch_secondary_fetch_failed = %v, want 1", got)
+	}
+	if got := testutil.Value(t, exporter.OrchStake); got != 250000.5 {
+		t.Errorf("livepeer_orch_stake = %v, want %v", got, 250000.5)
+	}
+	if got := testutil.Value(t, exporter.TotalStake); got != 1000000 {
+		t.Errorf("livepeer_orch_total_stake = %v, want %v", got, 1000000)
+	}
+	if got := testutil.Value(t, exporter.CurrentRound); got != 3302 {
+		t.Errorf("livepeer_orch_current_round = %v, want %v", got, 3302)
+	}
+
+	// The secondary stake is added again once it is fetched.
+	server.SetRoutes(t, infoRoutes()...)
+	fetchAndUpdate(t, exporter)
+	if got := testutil.Value(t, exporter.SecondaryFetchFailed); got != 0 {
+		t.Errorf("livepeer_orch_secondary_fetch_failed = %v, want 0", got)
+	}
+	if got := testutil.Value(t, exporter.OrchStake); got != 255000.5 {
+		t.Errorf("livepeer_orch_stake = %v, want %v", got, 255000.5)
+	}
+}
+
+func TestRecentPools(t *testing.T) {
+	// NOTE: The route only matches when the most recent pools are requested, since the subgraph
+	// returns the first pools by ID otherwise.
+	server := testutil.NewServer(t, testutil.Route{Contains: "pools(orderBy: round__id, orderDirection: desc, first: 31)", Fixture: "orch_info.json"})
+	exporter := newTestExporter(server, "")
+	fetchAndUpdate(t, exporter)
+
+	if got, want := testutil.Value(t, exporter.DelegatorRewardShare), 120.5*0.9/1000000; math.Abs(got-want) > 1e-15 {
+		t.Errorf("livepeer_orch_delegator_reward_share = %v, want %v", got, want)
+	}
+}
+
+// withPools returns the orchestrator info fixture with count reward pools, newest first, starting at
+// the current round.
+func withPools(t *testing.T, count int) string {
+	t.Helper()
+
+	var response map[string]any
+	if err := json.Unmarshal(testutil.Fixture(t, "orch_info.json"), &response); err != nil {
+		t.Fatal(err)
+	}
+	pools := make([]map[string]any, count)
+	for i := range pools {
+		pools[i] = map[string]any{
+			"rewardTokens": "120.5",
+			"rewardCut":    "100000",
+			"feeShare":     "500000",
+			"round":        map[string]string{"id": fmt.Sprint(3302 - i)},
+		}
+	}
+	response["data"].(map[string]any)["transcoder"].(map[string]any)["pools"] = pools
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCutHistoryWithManyPools(t *testing.T) {
+	// NOTE: The route only matches when enough pools are requested to cover the cut history.
+	server := testutil.NewServer(t, testutil.Route{Contains: "pools(orderBy: round__id, orderDirection: desc, first: 120)", Body: withPools(t, 150)})
+	exporter := NewOrchInfoExporter(testutil.OrchAddress, time.Minute, time.Minute, "", 250*time.Millisecond, "total", 120, time.Hour, server.URL, server.Client())
+	fetchAndUpdate(t, exporter)
+
+	if got := testutil.Count(exporter.RewardCutByRound); got != 120 {
+		t.Errorf("livepeer_orch_reward_cut_by_round has %d series, want 120", got)
+	}
+	if got := testutil.Count(exporter.FeeCutByRound); got != 120 {
+		t.Errorf("livepeer_orch_fee_cut_by_round has %d series, want 120", got)
+	}
+	for _, round := range []string{"3302", "3183"} {
+		if got := testutil.Value(t, exporter.RewardCutByRound.WithLabelValues(round)); got != 0.1 {
+			t.Errorf("livepeer_orch_reward_cut_by_round{round=%q} = %v, want 0.1", round, got)
+		}
+	}
+	if got := testutil.Value(t, exporter.RewardCallRatio); got != 1 {
+		t.Errorf("livepeer_orch_reward_call_ratio = %v, want 1", got)
+	}
+}
+
+// fetchAndUpdate fetches the data and updates the metrics of exporter once.
+func fetchAndUpdate(t *testing.T, exporter *OrchInfoExporter) {
+	t.Helper()
+
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+}
+
+func TestStakeTrends(t *testing.T) {
+	server := testutil.NewServer(t, infoRoutes()...)
+	clock := testutil.NewFakeClock(time.Unix(1704067200, 0))
+	exporter := newTestExporter(server, "")
+	exporter.clock = clock
+
+	// The trends stay zero until two fetches succeeded.
+	fetchAndUpdate(t, exporter)
+	if got := testutil.Value(t, exporter.StakeChangePerHour); got != 0 {
+		t.Errorf("livepeer_orch_stake_change_per_hour = %v, want 0", got)
+	}
+
+	// The stake grows by 1000 LPT and the orchestrator moves up one rank in an hour.
+	body := string(testutil.Fixture(t, "orch_info.json"))
+	body = strings.Replace(body, `"totalStake": "1000000"`, `"totalStake": "1001000"`, 1)
+	body = strings.Replace(body, `"stakeRanking": [`, `"stakeRanking": [{"id": "`+testutil.OrchAddress+`"},`, 1)
+	server.SetRoutes(t, testutil.Route{Body: body})
+	clock.Advance(time.Hour)
+	fetchAndUpdate(t, exporter)
+	if got := testutil.Value(t, exporter.StakeChangePerHour); got != 1000 {
+		t.Errorf("livepeer_orch_stake_change_per_hour = %v, want 1000", got)
+	}
+	if got := testutil.Value(t, exporter.StakeRankChange); got != -1 {
+		t.Errorf("livepeer_orch_stake_rank_change = %v, want -1", got)
+	}
+
+	// Updates without a new fetch do not add samples.
+	clock.Advance(time.Hour)
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.StakeChangePerHour); got != 1000 {
+		t.Errorf("livepeer_orch_stake_change_per_hour after update without fetch = %v, want 1000", got)
+	}
+
+	// After a restart the trends start over.
+	restarted := newTestExporter(server, "")
+	restarted.clock = clock
+	fetchAndUpdate(t, restarted)
+	if got := testutil.Value(t, restarted.StakeChangePerHour); got != 0 {
+		t.Errorf("livepeer_orch_stake_change_per_hour after restart = %v, want 0", got)
+	}
+	if got := testutil.Value(t, restarted.StakeRankChange); got != 0 {
+		t.Errorf("livepeer_orch_stake_rank_change after restart = %v, want 0", got)
+	}
+}
@@ -0,0 +1,179 @@
+// Package orch_node_exporter implements a Livepeer orchestrator node exporter that fetches the status
+// of the orchestrator's go-livepeer node from its CLI API and exposes the version of the running
+// software and the transcoding capacity of its remote transcoders via Prometheus metrics.
+package orch_node_exporter
+
+import (
+	"context"
+	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_node"
+
+// nodeStatus represents the structure of the go-livepeer '/status' API response.
+type nodeStatus struct {
+	Version               string
+	GolangRuntimeVersion  string
+	GOOS                  string
+	GOArch                string
+	RegisteredTranscoders []remoteTranscoder
+}
+
+// remoteTranscoder represents a remote transcoder that is registered with the orchestrator node.
+type remoteTranscoder struct {
+	Address  string
+	Capacity int
+}
+
+// OrchNodeExporter fetches the status of the orchestrator node and exposes its version and capacity via Prometheus metrics.
+type OrchNodeExporter struct {
+	// Metrics.
+	VersionInfo           *prometheus.GaugeVec
+	MaxSessions           *prometheus.GaugeVec
+	RegisteredTranscoders prometheus.Gauge
+	registry              *prometheus.Registry // The registry the metrics are registered with.
+
+	// Config settings.
+	fetchInterval  time.Duration // How often to fetch data.
+	updateInterval time.Duration // How often to update metrics.
+	clock          runner.Clock  // The clock that drives the fetch and update loops.
+	nodeEndpoint   string        // The endpoint to fetch data from.
+
+	// Data.
+	mu         sync.RWMutex // Guards the data returned by the API.
+	nodeStatus *nodeStatus  // The data returned by the API. Nil until it was fetched.
+
+	// Fetchers.
+	nodeStatusFetcher fetcher.Fetcher
+}
+
+// initMetrics initializes the orchestrator node metrics.
+func (m *OrchNodeExporter) initMetrics() {
+	m.VersionInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_node_version_info",
+			Help: "The go-livepeer version the orchestrator node runs. Always 1.",
+		},
+		[]string{"version", "go_version", "os", "arch"},
+	)
+	// NOTE: A vector without labels is used so that it is only exposed when remote transcoders advertise
+	// their capacity.
+	m.MaxSessions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_max_sessions",
+			Help: "The total number of concurrent sessions the remote transcoders registered with the orchestrator node can handle.",
+		},
+		nil,
+	)
+	m.RegisteredTranscoders = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_node_registered_transcoders",
+			Help: "The number of remote transcoders registered with the orchestrator node.",
+		},
+	)
+}
+
+// registerMetrics registers the orchestrator node metrics with the exporter's registry.
+func (m *OrchNodeExporter) registerMetrics() {
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		m.VersionInfo,
+		m.MaxSessions,
+		m.RegisteredTranscoders,
+	)
+}
+
+// updateMetrics updates the metrics with the data fetched from the go-livepeer CLI API.
+// NOTE: The version info is reset so that the previous version is removed after an upgrade.
+func (m *OrchNodeExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.nodeStatus == nil {
+		return
+	}
+	m.VersionInfo.Reset()
+	m.VersionInfo.WithLabelValues(m.nodeStatus.Version, m.nodeStatus.GolangRuntimeVersion, m.nodeStatus.GOOS, m.nodeStatus.GOArch).Set(1)
+
+	// Set the capacity of the remote transcoders.
+	// NOTE: The capacity of local transcoding is not reported by the node, so the max sessions are
+	// removed when no remote transcoders are registered.
+	m.RegisteredTranscoders.Set(float64(len(m.nodeStatus.RegisteredTranscoders)))
+	m.MaxSessions.Reset()
+	if len(m.nodeStatus.RegisteredTranscoders) > 0 {
+		var capacity int
+		for _, transcoder := range m.nodeStatus.RegisteredTranscoders {
+			capacity += transcoder.Capacity
+		}
+		m.MaxSessions.WithLabelValues().Set(float64(capacity))
+	}
+}
+
+// NewOrchNodeExporter creates a new OrchNodeExporter. The nodeURL is the base URL of the CLI API of
+// the orchestrator's go-livepeer node (e.g. 'http://localhost:7935').
+func NewOrchNodeExporter(fetchInterval time.Duration, updateInterval time.Duration, nodeURL string, client *http.Client) *OrchNodeExporter {
+	exporter := &OrchNodeExporter{
+		fetchInterval:  fetchInterval,
+		updateInterval: updateInterval,
+		clock:          runner.RealClock,
+		nodeEndpoint:   strings.TrimSuffix(nodeURL, "/") + "/status",
+	}
+
+	// Initialize fetcher.
+	exporter.nodeStatusFetcher = fetcher.Fetcher{
+		URL:    exporter.nodeEndpoint,
+		Client: client,
+	}
+
+	// Initialize metrics.
+	exporter.initMetrics()
+	exporter.registerMetrics()
+
+	return exporter
+}
+
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+func (m *OrchNodeExporter) fetchData() bool {
+	response := &nodeStatus{}
+	m.nodeStatusFetcher.Data = response
+	if err := m.nodeStatusFetcher.FetchData(); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator node status: %v", exporterName, err)
+		return false
+	}
+
+	m.mu.Lock()
+	m.nodeStatus = response
+	m.mu.Unlock()
+	return true
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchNodeExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchNodeExporter in logs and metrics.
+func (m *OrchNodeExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchNodeExporter's metrics are registered with.
+func (m *OrchNodeExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchNodeExporter and blocks until ctx is cancelled.
+func (m *OrchNodeExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
+}
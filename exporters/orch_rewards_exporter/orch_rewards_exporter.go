@@ -4,9 +4,14 @@
 package orch_rewards_exporter
 
 import (
+	"context"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
@@ -14,14 +19,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	rewardEventsEndpoint = constants.LivePeerSubgraphEndpoint
-)
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_rewards"
+
+// recentRewardEvents is the number of most recent reward events fetched per fetch.
+// NOTE: The subgraph returns the first 100 reward events by ID when no order and limit are given, so
+// the reward events are fetched newest first to keep the most recent reward calls in the metrics.
+const recentRewardEvents = 1000
 
 // graphqlQuery represents the GraphQL query to fetch data from the GraphQL API.
 const graphqlQueryTemplate = `
 {
-	rewardEvents(where: {delegate: "%s"}) {
+	rewardEvents(where: {delegate: "%s"}, orderBy: timestamp, orderDirection: desc, first: %d) {
 		transaction {
 			gasUsed
 			gasPrice
@@ -34,14 +43,83 @@ const graphqlQueryTemplate = `
 		}
 		rewardTokens
 	}
+	transcoder(id: "%s") {
+		totalVolumeETH
+	}
+	protocol(id: "0") {
+		currentRound {
+			id
+		}
+		lptPriceEth
+	}
+	_meta {
+		block {
+			number
+		}
+		hasIndexingErrors
+	}
+}
+`
+
+// claimedRewardsQueryTemplate represents the GraphQL query to fetch a page of the orchestrator's
+// reward events, starting after the given reward event ID.
+const claimedRewardsQueryTemplate = `
+{
+	rewardEvents(where: {delegate: "%s", id_gt: "%s"}, orderBy: id, orderDirection: asc, first: %d) {
+		id
+		rewardTokens
+	}
+}
+`
+
+// claimedRewardsPageSize is the number of reward events fetched per claimed rewards query.
+const claimedRewardsPageSize = 1000
+
+// MaxRewardHistoryRounds is the largest number of rounds the reward call history metric may cover.
+const MaxRewardHistoryRounds = 1000
+
+// rewardHistoryQueryTemplate represents the GraphQL query to fetch a page of the orchestrator's
+// reward events, newest first, that were emitted at or before the given timestamp.
+const rewardHistoryQueryTemplate = `
+{
+	rewardEvents(where: {delegate: "%s", timestamp_lte: %d}, orderBy: timestamp, orderDirection: desc, first: %d) {
+		id
+		timestamp
+		round {
+			id
+		}
+	}
 }
 `
 
+// rewardHistoryResponse represents the structure of the reward history GraphQL API response.
+type rewardHistoryResponse struct {
+	Data struct {
+		RewardEvents []struct {
+			ID        string
+			Timestamp int
+			Round     struct {
+				ID string
+			}
+		}
+	}
+}
+
+// claimedRewardsResponse represents the structure of the claimed rewards GraphQL API response.
+type claimedRewardsResponse struct {
+	Data struct {
+		RewardEvents []struct {
+			ID           string
+			RewardTokens util.Numeric
+		}
+	}
+}
+
 // rewardEvent represents the structure of the rewardEvent field contained in the GraphQL API response.
 type rewardEvent struct {
 	Transaction struct {
-		GasUsed     string
-		GasPrice    string
+		GasUsed     util.Numeric
+		GasPrice    util.Numeric
 		BlockNumber string
 		Timestamp   int
 		ID          string
@@ -49,54 +127,76 @@ type rewardEvent struct {
 	Round struct {
 		ID string
 	}
-	RewardTokens string
+	RewardTokens util.Numeric
 }
 
 // rewardEventResponse represents the structure of the GraphQL API response.
 type rewardEventResponse struct {
-	sync.Mutex
-
-	// Response data.
 	Data struct {
 		RewardEvents []rewardEvent
+		Transcoder   struct {
+			TotalVolumeETH util.Numeric
+		}
+		Protocol struct {
+			CurrentRound struct {
+				ID string
+			}
+			LptPriceEth util.Numeric
+		}
 	}
 }
 
 // OrchRewardsExporter fetches data from the API and exposes orchestrator's rewards metrics via Prometheus.
 type OrchRewardsExporter struct {
 	// Metrics.
-	RewardAmount      *prometheus.GaugeVec
-	RewardGasUsed     *prometheus.GaugeVec
-	RewardGasPrice    *prometheus.GaugeVec
-	RewardGasCost     *prometheus.GaugeVec
-	RewardBlockNumber *prometheus.GaugeVec
-	RewardBlockTime   *prometheus.GaugeVec
-	RewardRound       *prometheus.GaugeVec
-	DayRewards        prometheus.Gauge
-	WeekRewards       prometheus.Gauge
-	ThirtyDayRewards  prometheus.Gauge
-	NinetyDayRewards  prometheus.Gauge
-	YearRewards       prometheus.Gauge
-	TotalRewards      prometheus.Gauge
-	DayGasCost        prometheus.Gauge
-	WeekGasCost       prometheus.Gauge
-	ThirtyDayGasCost  prometheus.Gauge
-	NinetyDayGasCost  prometheus.Gauge
-	YearGasCost       prometheus.Gauge
-	TotalGasCost      prometheus.Gauge
+	RewardAmount        *prometheus.GaugeVec
+	RewardGasUsed       *prometheus.GaugeVec
+	RewardGasPrice      *prometheus.GaugeVec
+	RewardGasCost       *prometheus.GaugeVec
+	RewardBlockNumber   *prometheus.GaugeVec
+	RewardBlockTime     *prometheus.GaugeVec
+	RewardRound         *prometheus.GaugeVec
+	DayRewards          prometheus.Gauge
+	WeekRewards         prometheus.Gauge
+	ThirtyDayRewards    prometheus.Gauge
+	NinetyDayRewards    prometheus.Gauge
+	YearRewards         prometheus.Gauge
+	TotalRewards        prometheus.Gauge
+	DayGasCost          prometheus.Gauge
+	WeekGasCost         prometheus.Gauge
+	ThirtyDayGasCost    prometheus.Gauge
+	NinetyDayGasCost    prometheus.Gauge
+	YearGasCost         prometheus.Gauge
+	TotalGasCost        prometheus.Gauge
+	RewardsClaimed      prometheus.Gauge
+	CurrentRoundRewards prometheus.Gauge
+	RewardCalledByRound *prometheus.GaugeVec
+	RewardsToFeesRatio  prometheus.Gauge
+	RewardCalls         prometheus.Counter
+	registry            *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
 	orchAddress             string        // The orchestrator address to filter rewards by.
 	fetchInterval           time.Duration // How often to fetch data.
+	claimedFetchInterval    time.Duration // How often to fetch the claimed rewards total.
 	updateInterval          time.Duration // How often to update metrics.
+	clock                   runner.Clock  // The clock that drives the fetch and update loops.
 	orchRewardsEndpoint     string        // The endpoint to fetch data from.
 	orchRewardsGraphqlQuery string        // The GraphQL query to fetch data from the GraphQL API.
+	rewardHistoryRounds     int           // The number of past rounds covered by the reward call history metric.
 
 	// Data.
-	orchRewards *rewardEventResponse // The data returned by the API.
+	mu             sync.RWMutex         // Guards the data returned by the API.
+	orchRewards    *rewardEventResponse // The data returned by the API.
+	rewardsClaimed float64              // The sum of the rewards of all reward events returned by the API.
+	rewardRounds   map[int]bool         // The rounds in the reward call history window in which rewards were called.
+	historyRound   int                  // The current round the reward call history was fetched in.
+	lastRewardCall int                  // The timestamp of the newest reward call counted so far. -1 until the first fetch. Only used by fetchData.
 
 	// Fetchers.
-	orchRewardsFetcher fetcher.Fetcher
+	orchRewardsFetcher    fetcher.Fetcher
+	claimedRewardsFetcher fetcher.Fetcher
+	rewardHistoryFetcher  fetcher.Fetcher
 }
 
 // initMetrics initializes the orchestrator rewards metrics.
@@ -222,11 +322,43 @@ func (m *OrchRewardsExporter) initMetrics() {
 			Help: "Total gas cost for all reward transactions in Gwei.",
 		},
 	)
+	m.RewardsClaimed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_rewards_claimed",
+			Help: "Cumulative LPT rewards claimed by the orchestrator.",
+		},
+	)
+	m.CurrentRoundRewards = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_current_round_rewards",
+			Help: "The amount of LPT rewards claimed by the orchestrator in the current round.",
+		},
+	)
+	m.RewardCalledByRound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_reward_called_by_round",
+			Help: "Whether the orchestrator called reward in each of the last rounds.",
+		},
+		[]string{"round"},
+	)
+	m.RewardsToFeesRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_rewards_to_fees_ratio",
+			Help: "The ratio of the cumulative LPT rewards, valued in ETH at the current LPT price, to the cumulative ETH fees of the orchestrator.",
+		},
+	)
+	m.RewardCalls = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "livepeer_orch_reward_calls_total",
+			Help: "The number of reward calls of the orchestrator since the exporter started.",
+		},
+	)
 }
 
-// registerMetrics registers the orchestrator rewards metrics with Prometheus.
+// registerMetrics registers the orchestrator rewards metrics with the exporter's registry.
 func (m *OrchRewardsExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.RewardAmount,
 		m.RewardGasUsed,
 		m.RewardGasPrice,
@@ -246,13 +378,21 @@ func (m *OrchRewardsExporter) registerMetrics() {
 		m.YearGasCost,
 		m.RewardRound,
 		m.TotalGasCost,
+		m.RewardsClaimed,
+		m.CurrentRoundRewards,
+		m.RewardCalledByRound,
+		m.RewardsToFeesRatio,
+		m.RewardCalls,
 	)
 }
 
 // updateMetrics updates the metrics with the data fetched the Livepeer subgraph GraphQL API.
 func (m *OrchRewardsExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	// Create required Unix timestamps.
-	now := time.Now()
+	now := m.clock.Now()
 	dayAgo := now.AddDate(0, 0, -1)
 	weekAgo := now.AddDate(0, 0, -7)
 	ThirtyDaysAgo := now.AddDate(0, -1, 0)
@@ -263,11 +403,14 @@ func (m *OrchRewardsExporter) updateMetrics() {
 	var totalRewards, totalGasCost float64
 	var dayRewards, weekRewards, thirtyDayRewards, ninetyDayRewards, yearRewards float64
 	var dayGasCost, weekGasCost, thirtyDayGasCost, ninetyDayGasCost, yearGasCost float64
+	var currentRoundRewards float64
+	currentRound := m.orchRewards.Data.Protocol.CurrentRound.ID
 	for _, reward := range m.orchRewards.Data.RewardEvents {
-		amount, _ := strconv.ParseFloat(reward.RewardTokens, 64)
-		gasUsed, _ := strconv.ParseFloat(reward.Transaction.GasUsed, 64)
-		gasPrice, _ := strconv.ParseFloat(reward.Transaction.GasPrice, 64)
-		gasCost := (gasUsed * gasPrice) / 1e9
+		amount, _ := reward.RewardTokens.Float64()
+		amount = util.RoundTokenAmount(amount)
+		gasUsed, _ := reward.Transaction.GasUsed.Float64()
+		gasPrice, _ := reward.Transaction.GasPrice.Float64()
+		gasCost := util.RoundTokenAmount((gasUsed * gasPrice) / 1e9)
 		blockNumber, _ := strconv.ParseFloat(reward.Transaction.BlockNumber, 64)
 		blockTime, _ := strconv.ParseFloat(strconv.Itoa(reward.Transaction.Timestamp), 64)
 		round, _ := strconv.ParseFloat(reward.Round.ID, 64)
@@ -303,6 +446,12 @@ func (m *OrchRewardsExporter) updateMetrics() {
 		}
 		totalRewards += amount
 		totalGasCost += gasCost
+
+		// NOTE: The reward events are fetched newest first, so the events of the current round are
+		// always included.
+		if currentRound != "" && reward.Round.ID == currentRound {
+			currentRoundRewards += amount
+		}
 	}
 
 	// Set the period rewards and gas costs.
@@ -318,17 +467,44 @@ func (m *OrchRewardsExporter) updateMetrics() {
 	m.NinetyDayGasCost.Set(ninetyDayGasCost)
 	m.YearGasCost.Set(yearGasCost)
 	m.TotalGasCost.Set(totalGasCost)
+	m.RewardsClaimed.Set(m.rewardsClaimed)
+	m.CurrentRoundRewards.Set(currentRoundRewards)
+
+	// Calculate and set the rewards to fees ratio.
+	// NOTE: The rewards are valued in ETH at the current LPT price, since rewards and fees are paid in
+	// different tokens. Skipped when no fees were earned or the LPT price is unknown.
+	fees, _ := m.orchRewards.Data.Transcoder.TotalVolumeETH.Float64()
+	lptPriceETH, _ := m.orchRewards.Data.Protocol.LptPriceEth.Float64()
+	if fees > 0 && lptPriceETH > 0 {
+		m.RewardsToFeesRatio.Set(m.rewardsClaimed * lptPriceETH / fees)
+	}
+
+	// Reset the reward call history so that rounds outside the history window are removed.
+	// NOTE: The history is only set once it was fetched.
+	m.RewardCalledByRound.Reset()
+	if m.historyRound > 0 {
+		for round := max(m.historyRound-m.rewardHistoryRounds+1, 0); round <= m.historyRound; round++ {
+			m.RewardCalledByRound.WithLabelValues(strconv.Itoa(round)).Set(util.BoolToFloat64(m.rewardRounds[round]))
+		}
+	}
 }
 
-// NewOrchRewardsExporter creates a new OrchRewardsExporter.
-func NewOrchRewardsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration) *OrchRewardsExporter {
+// NewOrchRewardsExporter creates a new OrchRewardsExporter. Since the claimed rewards total requires
+// fetching all reward events of the orchestrator, it is fetched every claimedFetchInterval instead.
+// The rewardHistoryRounds sets how many past rounds the reward call history metric covers and should
+// not exceed MaxRewardHistoryRounds.
+func NewOrchRewardsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, claimedFetchInterval time.Duration, rewardHistoryRounds int, endpoint string, client *http.Client) *OrchRewardsExporter {
 	exporter := &OrchRewardsExporter{
 		orchAddress:             orchAddress,
 		fetchInterval:           fetchInterval,
+		claimedFetchInterval:    claimedFetchInterval,
 		updateInterval:          updateInterval,
-		orchRewardsEndpoint:     rewardEventsEndpoint,
-		orchRewardsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress),
+		clock:                   runner.RealClock,
+		orchRewardsEndpoint:     endpoint,
+		orchRewardsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress, recentRewardEvents, orchAddress),
+		rewardHistoryRounds:     rewardHistoryRounds,
 		orchRewards:             &rewardEventResponse{},
+		lastRewardCall:          -1,
 	}
 
 	// Create request headers.
@@ -336,11 +512,21 @@ func NewOrchRewardsExporter(orchAddress string, fetchInterval time.Duration, upd
 		"X-Device-ID": {fmt.Sprintf(constants.ClientIDTemplate, orchAddress)},
 	}
 
-	// Initialize fetcher.
+	// Initialize fetchers.
 	exporter.orchRewardsFetcher = fetcher.Fetcher{
 		URL:     exporter.orchRewardsEndpoint,
-		Data:    &exporter.orchRewards,
 		Headers: headers,
+		Client:  client,
+	}
+	exporter.claimedRewardsFetcher = fetcher.Fetcher{
+		URL:     exporter.orchRewardsEndpoint,
+		Headers: headers,
+		Client:  client,
+	}
+	exporter.rewardHistoryFetcher = fetcher.Fetcher{
+		URL:     exporter.orchRewardsEndpoint,
+		Headers: headers,
+		Client:  client,
 	}
 
 	// Initialize metrics.
@@ -350,33 +536,137 @@ func NewOrchRewardsExporter(orchAddress string, fetchInterval time.Duration, upd
 	return exporter
 }
 
-// Start starts the OrchRewardsExporter.
-func (m *OrchRewardsExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchRewardsFetcher.FetchGraphQLData(m.orchRewardsGraphqlQuery)
-	m.updateMetrics()
-
-	// Start fetcher in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			m.orchRewards.Mutex.Lock()
-			m.orchRewardsFetcher.FetchGraphQLData(m.orchRewardsGraphqlQuery)
-			m.orchRewards.Mutex.Unlock()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+func (m *OrchRewardsExporter) fetchData() bool {
+	response := &rewardEventResponse{}
+	m.orchRewardsFetcher.Data = response
+	if err := m.orchRewardsFetcher.FetchGraphQLData(m.orchRewardsGraphqlQuery); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator rewards data: %v", exporterName, err)
+		return false
+	}
+
+	m.countRewardCalls(response.Data.RewardEvents)
+
+	m.mu.Lock()
+	m.orchRewards = response
+	m.mu.Unlock()
+
+	if currentRound, err := strconv.Atoi(response.Data.Protocol.CurrentRound.ID); err == nil {
+		m.fetchRewardHistory(currentRound)
+	}
+	return true
+}
+
+// countRewardCalls counts the reward calls made after the newest reward call counted so far, with
+// the transaction hash as exemplar. The first fetch only establishes the baseline.
+func (m *OrchRewardsExporter) countRewardCalls(events []rewardEvent) {
+	newest := max(m.lastRewardCall, 0)
+	for _, event := range events {
+		if m.lastRewardCall >= 0 && event.Transaction.Timestamp > m.lastRewardCall {
+			util.AddWithExemplar(m.RewardCalls, 1, prometheus.Labels{"tx_hash": event.Transaction.ID})
+		}
+		newest = max(newest, event.Transaction.Timestamp)
+	}
+	m.lastRewardCall = newest
+}
+
+// fetchRewardHistory pages through the reward events of the orchestrator, newest first, until it
+// reaches the start of the reward call history window and publishes the rounds in which rewards were
+// called. The previous history is kept when any of the pages fails to fetch.
+// NOTE: Pages overlap at the timestamp of the oldest event of the previous page, so that events
+// sharing a timestamp across a page boundary are not skipped. Events are deduplicated by ID.
+func (m *OrchRewardsExporter) fetchRewardHistory(currentRound int) {
+	startRound := currentRound - m.rewardHistoryRounds + 1
+	rewardRounds := make(map[int]bool)
+	seen := make(map[string]bool)
+	before := m.clock.Now().Unix()
+	for {
+		response := &rewardHistoryResponse{}
+		m.rewardHistoryFetcher.Data = response
+		query := fmt.Sprintf(rewardHistoryQueryTemplate, m.orchAddress, before, claimedRewardsPageSize)
+		if err := m.rewardHistoryFetcher.FetchGraphQLData(query); err != nil {
+			log.Printf("%s exporter: error fetching orchestrator reward history data: %v", exporterName, err)
+			return
+		}
+
+		reachedStart, newEvents := false, 0
+		for _, event := range response.Data.RewardEvents {
+			if seen[event.ID] {
+				continue
+			}
+			seen[event.ID] = true
+			newEvents++
+			round, err := strconv.Atoi(event.Round.ID)
+			if err != nil {
+				continue
+			}
+			if round < startRound {
+				reachedStart = true
+				break
+			}
+			rewardRounds[round] = true
+		}
+		// Stop when a full page only contains events seen before, e.g. when more events than fit in a
+		// page share a timestamp, since the next page would be the same.
+		if reachedStart || newEvents == 0 || len(response.Data.RewardEvents) < claimedRewardsPageSize {
+			break
 		}
-	}()
+		before = int64(response.Data.RewardEvents[len(response.Data.RewardEvents)-1].Timestamp)
+	}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+	m.mu.Lock()
+	m.rewardRounds = rewardRounds
+	m.historyRound = currentRound
+	m.mu.Unlock()
+}
+
+// fetchClaimedRewards pages through all reward events of the orchestrator and publishes the sum of
+// their rewards. The previous total is kept when any of the pages fails to fetch.
+func (m *OrchRewardsExporter) fetchClaimedRewards() {
+	var total float64
+	lastID := ""
+	for {
+		response := &claimedRewardsResponse{}
+		m.claimedRewardsFetcher.Data = response
+		query := fmt.Sprintf(claimedRewardsQueryTemplate, m.orchAddress, lastID, claimedRewardsPageSize)
+		if err := m.claimedRewardsFetcher.FetchGraphQLData(query); err != nil {
+			log.Printf("%s exporter: error fetching orchestrator claimed rewards data: %v", exporterName, err)
+			return
+		}
 
-		for range ticker.C {
-			m.orchRewards.Mutex.Lock()
-			m.updateMetrics()
-			m.orchRewards.Mutex.Unlock()
+		for _, event := range response.Data.RewardEvents {
+			amount, _ := event.RewardTokens.Float64()
+			total += util.RoundTokenAmount(amount)
 		}
-	}()
+		if len(response.Data.RewardEvents) < claimedRewardsPageSize {
+			break
+		}
+		lastID = response.Data.RewardEvents[len(response.Data.RewardEvents)-1].ID
+	}
+
+	m.mu.Lock()
+	m.rewardsClaimed = total
+	m.mu.Unlock()
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchRewardsExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchRewardsExporter in logs and metrics.
+func (m *OrchRewardsExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchRewardsExporter's metrics are registered with.
+func (m *OrchRewardsExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchRewardsExporter and blocks until ctx is cancelled.
+func (m *OrchRewardsExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics, runner.Loop{Interval: m.claimedFetchInterval, Fn: m.fetchClaimedRewards})
 }
@@ -0,0 +1,184 @@
+package orch_rewards_exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"livepeer-exporter/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestExporter returns an OrchRewardsExporter that fetches the rewards from server.
+func newTestExporter(server *testutil.Server) *OrchRewardsExporter {
+	return NewOrchRewardsExporter(testutil.OrchAddress, time.Minute, time.Minute, time.Hour, 10, server.URL+"/graphql", server.Client())
+}
+
+// rewardsRoutes returns the routes that serve the given rewards response and an empty reward history.
+func rewardsRoutes(rewards string) []testutil.Route {
+	return []testutil.Route{
+		{Path: "/graphql", Contains: recentRewardsQuery, Body: rewards},
+		{Path: "/graphql", Body: `{"data":{"rewardEvents":[]}}`},
+	}
+}
+
+func TestCurrentRoundRewardsResetOnRoundChange(t *testing.T) {
+	rewards := string(testutil.Fixture(t, "orch_rewards.json"))
+	server := testutil.NewServer(t, rewardsRoutes(rewards)...)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.CurrentRoundRewards); got != 120.5 {
+		t.Fatalf("livepeer_orch_current_round_rewards = %v, want %v", got, 120.5)
+	}
+
+	// The next round starts without a reward call, so the rewards of the previous round must not carry over.
+	nextRound := strings.Replace(rewards, `"currentRound": {
+        "id": "3302"`, `"currentRound": {
+        "id": "3303"`, 1)
+	server.SetRoutes(t, rewardsRoutes(nextRound)...)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.CurrentRoundRewards); got != 0 {
+		t.Errorf("livepeer_orch_current_round_rewards = %v, want 0", got)
+	}
+	if got := testutil.Value(t, exporter.TotalRewards); got != 239.75 {
+		t.Errorf("livepeer_orch_total_rewards = %v, want %v", got, 239.75)
+	}
+}
+
+// recentRewardsQuery is contained in the rewards query only when the most recent reward events are
+// requested.
+const recentRewardsQuery = `\"}, orderBy: timestamp, orderDirection: desc, first: 1000)`
+
+// withRewardEvents returns the rewards fixture with count reward events of 120 LPT, newest first and
+// called once per round from the given round, a day apart.
+func withRewardEvents(t *testing.T, newestRound, count int) string {
+	t.Helper()
+
+	var response map[string]any
+	if err := json.Unmarshal(testutil.Fixture(t, "orch_rewards.json"), &response); err != nil {
+		t.Fatal(err)
+	}
+	events := make([]map[string]any, count)
+	for i := range events {
+		round := newestRound - i
+		events[i] = map[string]any{
+			"transaction": map[string]any{
+				"gasUsed":     "300000",
+				"gasPrice":    "100000000",
+				"blockNumber": fmt.Sprint(19200010 - (3302-round)*7200),
+				"timestamp":   1704067300 - (3302-round)*86400,
+				"id":          fmt.Sprintf("0x%064x", round),
+			},
+			"round":        map[string]string{"id": fmt.Sprint(round)},
+			"rewardTokens": "120",
+		}
+	}
+	response["data"].(map[string]any)["rewardEvents"] = events
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRewardCallsWithManyEvents(t *testing.T) {
+	server := testutil.NewServer(t, rewardsRoutes(withRewardEvents(t, 3301, 150))...)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	if got := testutil.Value(t, exporter.RewardCalls); got != 0 {
+		t.Fatalf("livepeer_orch_reward_calls_total = %v, want 0", got)
+	}
+
+	// Only the reward call made since the previous fetch is counted.
+	server.SetRoutes(t, rewardsRoutes(withRewardEvents(t, 3302, 150))...)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	if got := testutil.Value(t, exporter.RewardCalls); got != 1 {
+		t.Errorf("livepeer_orch_reward_calls_total = %v, want 1", got)
+	}
+}
+
+func TestCurrentRoundRewardsWithManyEvents(t *testing.T) {
+	server := testutil.NewServer(t, rewardsRoutes(withRewardEvents(t, 3302, 150))...)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	if got := testutil.Value(t, exporter.CurrentRoundRewards); got != 120 {
+		t.Errorf("livepeer_orch_current_round_rewards = %v, want 120", got)
+	}
+}
+
+// historyPage returns a reward history response with an event per given ID, emitted at the given
+// timestamp in the given round.
+func historyPage(t *testing.T, ids []string, timestamps []int64, rounds []int) string {
+	t.Helper()
+
+	type event struct {
+		ID        string `json:"id"`
+		Timestamp int64  `json:"timestamp"`
+		Round     struct {
+			ID string `json:"id"`
+		} `json:"round"`
+	}
+	events := make([]event, len(ids))
+	for i := range ids {
+		events[i].ID, events[i].Timestamp = ids[i], timestamps[i]
+		events[i].Round.ID = fmt.Sprint(rounds[i])
+	}
+	data, err := json.Marshal(map[string]any{"data": map[string]any{"rewardEvents": events}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRewardHistoryPageBoundary(t *testing.T) {
+	now := time.Unix(1704067800, 0)
+	server := testutil.NewServer(t)
+	exporter := NewOrchRewardsExporter(testutil.OrchAddress, time.Minute, time.Minute, time.Hour, claimedRewardsPageSize+1, server.URL+"/graphql", server.Client())
+	exporter.clock = testutil.NewFakeClock(now)
+
+	// The first page is full and its oldest event shares its timestamp with the newest event of the
+	// second page, which must not be skipped.
+	var ids []string
+	var timestamps []int64
+	var rounds []int
+	for i := 0; i < claimedRewardsPageSize; i++ {
+		ids = append(ids, fmt.Sprintf("event-%d", i))
+		timestamps = append(timestamps, now.Unix()-int64(i))
+		rounds = append(rounds, 3302-i)
+	}
+	boundary := timestamps[len(timestamps)-1]
+	secondPage := historyPage(t,
+		[]string{ids[len(ids)-1], "event-boundary"},
+		[]int64{boundary, boundary},
+		[]int{rounds[len(rounds)-1], rounds[len(rounds)-1] - 1},
+	)
+	server.SetRoutes(t,
+		testutil.Route{Path: "/graphql", Contains: fmt.Sprintf("timestamp_lte: %d", now.Unix()), Body: historyPage(t, ids, timestamps, rounds)},
+		testutil.Route{Path: "/graphql", Contains: fmt.Sprintf("timestamp_lte: %d", boundary), Body: secondPage},
+	)
+
+	exporter.fetchRewardHistory(3302)
+	if hits := server.Hits("/graphql"); hits != 2 {
+		t.Errorf("reward history endpoint received %d requests, want 2", hits)
+	}
+	if got := len(exporter.rewardRounds); got != claimedRewardsPageSize+1 {
+		t.Errorf("reward history contains %d rounds, want %d", got, claimedRewardsPageSize+1)
+	}
+	if !exporter.rewardRounds[3302-claimedRewardsPageSize] {
+		t.Errorf("reward call in round %d at the page boundary was skipped", 3302-claimedRewardsPageSize)
+	}
+}
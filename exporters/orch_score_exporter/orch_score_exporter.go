@@ -1,32 +1,58 @@
 // Package orch_score_exporter implements a Livepeer orchestrator score exporter that fetches data
 // from the Livepeer orchestrator score API and exposes orchestrator score data via Prometheus metrics.
+// Optionally, the full leaderboard is fetched to compare the orchestrator's score to the network.
 package orch_score_exporter
 
 import (
+	"context"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	orchScoreEndpointTemplate = "https://explorer.livepeer.org/api/score/%s"
-)
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_score"
 
 // orchScore represents the structure of the data returned by the Livepeer orchestrator score API.
 type orchScore struct {
-	Mutex sync.Mutex
-
-	// Response data.
 	PricePerPixel   float64
 	SuccessRates    map[string]float64
 	RoundTripScores map[string]float64
 	Scores          map[string]float64
 }
 
+// leaderboardStats represents the stats of an orchestrator in a region in the leaderboard API response.
+type leaderboardStats struct {
+	Score float64 `json:"score"`
+}
+
+// leaderboard represents the structure of the data returned by the leaderboard API. It maps the
+// orchestrator addresses to their stats per region.
+type leaderboard map[string]map[string]leaderboardStats
+
+// median returns the median of values. It returns 0 when values is empty.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
 // OrchScoreExporter fetches data from the Livepeer orchestrator score API and exposes it via Prometheus metrics.
 type OrchScoreExporter struct {
 	// Metrics.
@@ -34,17 +60,27 @@ type OrchScoreExporter struct {
 	SuccessRates    *prometheus.GaugeVec
 	RoundTripScores *prometheus.GaugeVec
 	Scores          *prometheus.GaugeVec
+	SourceInfo      *prometheus.GaugeVec
+	ScoreVsMedian   *prometheus.GaugeVec
+	registry        *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
 	fetchInterval    time.Duration // How often to fetch data.
 	updateInterval   time.Duration // How often to update metrics.
+	clock            runner.Clock  // The clock that drives the fetch and update loops.
 	orchInfoEndpoint string        // The endpoint to fetch data from.
+	backupEndpoint   string        // The endpoint to fetch data from when the endpoint fails.
+	orchAddress      string        // The address of the orchestrator.
 
 	// Data.
-	orchScore *orchScore // The data returned by the API.
+	mu          sync.RWMutex // Guards the data returned by the API.
+	orchScore   *orchScore   // The data returned by the API.
+	usingBackup bool         // Whether the data was fetched from the backup endpoint.
+	leaderboard leaderboard  // The leaderboard returned by the leaderboard API. Nil until it was fetched.
 
 	// Fetchers.
-	orchScoreFetcher fetcher.Fetcher
+	orchScoreFetcher   fetcher.Fetcher
+	leaderboardFetcher *fetcher.Fetcher // Nil when no leaderboard endpoint is set.
 }
 
 // initMetrics initializes the orchestrator score metrics.
@@ -76,20 +112,40 @@ func (m *OrchScoreExporter) initMetrics() {
 		},
 		[]string{"region"},
 	)
+	m.SourceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_score_source_info",
+			Help: "The endpoint the orchestrator score data was last fetched from. The value is always 1.",
+		},
+		[]string{"source"},
+	)
+	m.ScoreVsMedian = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_score_vs_median",
+			Help: "The leaderboard score of the orchestrator minus the median leaderboard score of all orchestrators per region.",
+		},
+		[]string{"region"},
+	)
 }
 
-// registerMetrics registers the orchestrator score metrics with Prometheus.
+// registerMetrics registers the orchestrator score metrics with the exporter's registry.
 func (m *OrchScoreExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.PricePerPixel,
 		m.SuccessRates,
 		m.RoundTripScores,
 		m.Scores,
+		m.SourceInfo,
+		m.ScoreVsMedian,
 	)
 }
 
 // updateMetrics updates the metrics with the data fetched from the Livepeer orchestrator score API.
 func (m *OrchScoreExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	// Update the PricePerPixel metric
 	m.PricePerPixel.Set(m.orchScore.PricePerPixel)
 
@@ -107,16 +163,54 @@ func (m *OrchScoreExporter) updateMetrics() {
 	for region, score := range m.orchScore.Scores {
 		m.Scores.WithLabelValues(region).Set(score / 10)
 	}
+
+	// Update the SourceInfo metric
+	source := "primary"
+	if m.usingBackup {
+		source = "backup"
+	}
+	m.SourceInfo.Reset()
+	m.SourceInfo.WithLabelValues(source).Set(1)
+
+	// Update the ScoreVsMedian metric
+	if m.leaderboard != nil {
+		m.updateScoreVsMedian()
+	}
+}
+
+// updateScoreVsMedian compares the leaderboard score of the orchestrator with the median score of
+// all orchestrators in each region the orchestrator is scored in.
+// NOTE: The caller must hold the read lock.
+func (m *OrchScoreExporter) updateScoreVsMedian() {
+	regionScores := make(map[string][]float64)
+	for _, regions := range m.leaderboard {
+		for region, stats := range regions {
+			regionScores[region] = append(regionScores[region], stats.Score)
+		}
+	}
+
+	m.ScoreVsMedian.Reset()
+	for region, stats := range m.leaderboard[m.orchAddress] {
+		m.ScoreVsMedian.WithLabelValues(region).Set(stats.Score - median(regionScores[region]))
+	}
 }
 
-// NewOrchScoreExporter creates a new OrchScoreExporter.
-func NewOrchScoreExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration) *OrchScoreExporter {
+// NewOrchScoreExporter creates a new OrchScoreExporter. The endpointTemplate and the optional
+// backupEndpointTemplate are formatted with the orchestrator address. When leaderboardEndpoint is
+// set, the full leaderboard is fetched from it to compare the score with the network median. When
+// cacheBust is set, the explorer's CDN cache is bypassed.
+func NewOrchScoreExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, endpointTemplate string, backupEndpointTemplate string, leaderboardEndpoint string, cacheBust bool, client *http.Client) *OrchScoreExporter {
 	exporter := &OrchScoreExporter{
 		fetchInterval:    fetchInterval,
 		updateInterval:   updateInterval,
-		orchInfoEndpoint: fmt.Sprintf(orchScoreEndpointTemplate, orchAddress),
+		clock:            runner.RealClock,
+		orchInfoEndpoint: fmt.Sprintf(endpointTemplate, orchAddress),
+		orchAddress:      strings.ToLower(orchAddress),
 		orchScore:        &orchScore{},
 	}
+	if backupEndpointTemplate != "" {
+		exporter.backupEndpoint = fmt.Sprintf(backupEndpointTemplate, orchAddress)
+	}
 
 	// Create request headers.
 	headers := map[string][]string{
@@ -125,9 +219,18 @@ func NewOrchScoreExporter(orchAddress string, fetchInterval time.Duration, updat
 
 	// Initialize fetcher.
 	exporter.orchScoreFetcher = fetcher.Fetcher{
-		URL:     exporter.orchInfoEndpoint,
-		Data:    &exporter.orchScore,
-		Headers: headers,
+		URL:       exporter.orchInfoEndpoint,
+		BackupURL: exporter.backupEndpoint,
+		Headers:   headers,
+		CacheBust: cacheBust,
+		Client:    client,
+	}
+	if leaderboardEndpoint != "" {
+		exporter.leaderboardFetcher = &fetcher.Fetcher{
+			URL:     leaderboardEndpoint,
+			Headers: headers,
+			Client:  client,
+		}
 	}
 
 	// Initialize metrics.
@@ -137,33 +240,64 @@ func NewOrchScoreExporter(orchAddress string, fetchInterval time.Duration, updat
 	return exporter
 }
 
-// Start starts the OrchScoreExporter.
-func (m *OrchScoreExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchScoreFetcher.FetchData()
-	m.updateMetrics()
-
-	// Start fetcher in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			m.orchScore.Mutex.Lock()
-			m.orchScoreFetcher.FetchData()
-			m.orchScore.Mutex.Unlock()
-		}
-	}()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+func (m *OrchScoreExporter) fetchData() bool {
+	response := &orchScore{}
+	m.orchScoreFetcher.Data = response
+	if err := m.orchScoreFetcher.FetchData(); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator score data: %v", exporterName, err)
+		return false
+	}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+	m.mu.Lock()
+	m.orchScore = response
+	m.usingBackup = m.orchScoreFetcher.UsingBackup
+	m.mu.Unlock()
 
-		for range ticker.C {
-			m.orchScore.Mutex.Lock()
-			m.updateMetrics()
-			m.orchScore.Mutex.Unlock()
-		}
-	}()
+	if m.leaderboardFetcher != nil {
+		m.fetchLeaderboard()
+	}
+	return true
+}
+
+// fetchLeaderboard fetches the full leaderboard. When it fails, the previous leaderboard is kept.
+func (m *OrchScoreExporter) fetchLeaderboard() {
+	board := leaderboard{}
+	m.leaderboardFetcher.Data = &board
+	if err := m.leaderboardFetcher.FetchData(); err != nil {
+		log.Printf("%s exporter: error fetching leaderboard data: %v", exporterName, err)
+		return
+	}
+
+	// NOTE: The addresses are lowercased so that they match regardless of their checksum casing.
+	lowered := make(leaderboard, len(board))
+	for address, regions := range board {
+		lowered[strings.ToLower(address)] = regions
+	}
+
+	m.mu.Lock()
+	m.leaderboard = lowered
+	m.mu.Unlock()
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchScoreExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchScoreExporter in logs and metrics.
+func (m *OrchScoreExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchScoreExporter's metrics are registered with.
+func (m *OrchScoreExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchScoreExporter and blocks until ctx is cancelled.
+func (m *OrchScoreExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
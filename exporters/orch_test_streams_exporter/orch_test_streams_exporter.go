@@ -4,18 +4,27 @@
 package orch_test_streams_exporter
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	orchDelegatorsEndpointTemplate = "https://leaderboard-serverless.vercel.app/api/raw_stats?orchestrator=%s"
-)
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_test_streams"
 
 // testStreams represents the data structure of the test streams field contained in the API response.
 type testStreams struct {
@@ -26,13 +35,65 @@ type testStreams struct {
 	DownloadTime  float64 `json:"download_time"`
 	TranscodeTime float64 `json:"transcode_time"`
 	RoundTripTime float64 `json:"round_trip_time"`
+	Errors        []testStreamError
+}
+
+// testStreamError represents an error of a test stream. The API returns errors either as plain
+// messages or as objects containing the message.
+type testStreamError string
+
+// UnmarshalJSON decodes a test stream error from a plain message or an object with an 'error' or
+// 'message' field.
+func (e *testStreamError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*e = testStreamError(message)
+		return nil
+	}
+
+	var object struct {
+		Error   string
+		Message string
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*e = testStreamError(object.Error + object.Message)
+	return nil
+}
+
+// ErrorTypes contains the error types of the 'livepeer_orch_test_stream_errors' metric.
+var ErrorTypes = []string{"timeout", "upload_error", "download_error", "transcode_error", "connection_error", "other"}
+
+// errorTypeKeywords maps the keywords of free-form error messages to their error type. The keywords
+// are checked in order, so more specific error types come first.
+var errorTypeKeywords = []struct {
+	errorType string
+	keywords  []string
+}{
+	{"timeout", []string{"timeout", "timed out", "deadline exceeded"}},
+	{"transcode_error", []string{"transcod"}},
+	{"upload_error", []string{"upload", "push"}},
+	{"download_error", []string{"download", "pull", "playlist"}},
+	{"connection_error", []string{"connection", "no such host", "eof", "unreachable"}},
+}
+
+// errorType normalizes a free-form error message into one of the ErrorTypes, so that the cardinality
+// of the error metric stays bounded.
+func errorType(message string) string {
+	message = strings.ToLower(message)
+	for _, t := range errorTypeKeywords {
+		for _, keyword := range t.keywords {
+			if strings.Contains(message, keyword) {
+				return t.errorType
+			}
+		}
+	}
+	return "other"
 }
 
 // orchTestStreams represents the structure of the data returned by the  API.
 type orchTestStreams struct {
-	sync.Mutex
-
-	// Response data.
 	FRA []testStreams
 	LAX []testStreams
 	LON []testStreams
@@ -43,22 +104,73 @@ type orchTestStreams struct {
 	SIN []testStreams
 }
 
+// AggregateStats contains the statistics of the aggregated test stream metrics.
+var AggregateStats = []string{"min", "avg", "max", "p95"}
+
+// LatencyQuantiles contains the quantiles of the 'livepeer_orch_test_stream_latency_seconds' metric.
+var LatencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// quantile returns the q-quantile of the given sorted values using the nearest-rank method.
+func quantile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// aggregate calculates the AggregateStats of the given values.
+func aggregate(values []float64) map[string]float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	var sum float64
+	for _, value := range sorted {
+		sum += value
+	}
+	return map[string]float64{
+		"min": sorted[0],
+		"avg": sum / float64(len(sorted)),
+		"max": sorted[len(sorted)-1],
+		"p95": quantile(sorted, 0.95),
+	}
+}
+
 // TestStreamsExporter fetches data from the API and exposes orchestrator's test streams metrics via Prometheus.
 type TestStreamsExporter struct {
 	// Metrics.
-	SuccessRate   *prometheus.GaugeVec
-	UploadTime    *prometheus.GaugeVec
-	DownloadTime  *prometheus.GaugeVec
-	TranscodeTime *prometheus.GaugeVec
-	RoundTripTime *prometheus.GaugeVec
+	SuccessRate            *prometheus.GaugeVec
+	UploadTime             *prometheus.GaugeVec
+	DownloadTime           *prometheus.GaugeVec
+	TranscodeTime          *prometheus.GaugeVec
+	RoundTripTime          *prometheus.GaugeVec
+	Total                  prometheus.Gauge
+	Passing                prometheus.Gauge
+	RecentUploadTime       *prometheus.GaugeVec
+	RecentDownloadTime     *prometheus.GaugeVec
+	RecentTranscodeTime    *prometheus.GaugeVec
+	Errors                 *prometheus.GaugeVec
+	SuccessRateAggregate   *prometheus.GaugeVec
+	UploadTimeAggregate    *prometheus.GaugeVec
+	DownloadTimeAggregate  *prometheus.GaugeVec
+	TranscodeTimeAggregate *prometheus.GaugeVec
+	RoundTripTimeAggregate *prometheus.GaugeVec
+	Latency                *prometheus.GaugeVec
+	SuccessRateAvg         prometheus.Gauge
+	registry               *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
 	fetchInterval           time.Duration // How often to fetch data.
 	updateInterval          time.Duration // How often to update metrics.
+	clock                   runner.Clock  // The clock that drives the fetch and update loops.
 	orchTestStreamsEndpoint string        // The endpoint to fetch data from.
+	successThreshold        float64       // The minimum success rate for a test stream to count as passing.
+	recentResults           int           // The number of recent test streams per region exposed in the recent metrics.
+	aggregateMetrics        bool          // Whether to expose aggregates across regions instead of per-region metrics.
 
 	// Data.
-	orchTestStreams *orchTestStreams // The data returned by the API.
+	mu                sync.RWMutex        // Guards the data returned by the API and the success rate window updateMetrics derives from it.
+	orchTestStreams   *orchTestStreams    // The data returned by the API.
+	fetchedAt         time.Time           // When the data was fetched.
+	prevFetchedAt     time.Time           // When the data last added to the success rate window was fetched.
+	successRateWindow *util.RollingWindow // The average success rate across regions of the fetches in the window.
 
 	// Fetchers.
 	orchTestStreamsFetcher fetcher.Fetcher
@@ -86,21 +198,99 @@ func (m *TestStreamsExporter) initMetrics() {
 		Name: "livepeer_orch_test_stream_round_trip_time",
 		Help: "Test stream round trip time per region",
 	}, []string{"region", "orchestrator"})
+	m.Total = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_streams_total",
+		Help: "Number of regions that have test stream data.",
+	})
+	m.Passing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_streams_passing",
+		Help: "Number of regions whose latest test stream success rate meets the success threshold.",
+	})
+	m.RecentUploadTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_recent_upload_time",
+		Help: "Test stream 2-segment upload time of each recent test stream per region. Index 0 is the most recent test stream.",
+	}, []string{"region", "orchestrator", "index"})
+	m.RecentDownloadTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_recent_download_time",
+		Help: "Test stream 2-segment download time of each recent test stream per region. Index 0 is the most recent test stream.",
+	}, []string{"region", "orchestrator", "index"})
+	m.RecentTranscodeTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_recent_transcode_time",
+		Help: "Test stream 2-segment transcode time of each recent test stream per region. Index 0 is the most recent test stream.",
+	}, []string{"region", "orchestrator", "index"})
+	m.Errors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_errors",
+		Help: "The number of errors per type in the test streams returned per region.",
+	}, []string{"region", "error_type"})
+	m.SuccessRateAggregate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_success_rate_aggregate",
+		Help: "The min, avg, max and p95 of the latest test stream success rate across regions.",
+	}, []string{"stat"})
+	m.UploadTimeAggregate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_upload_time_aggregate",
+		Help: "The min, avg, max and p95 of the latest test stream 2-segment upload time across regions.",
+	}, []string{"stat"})
+	m.DownloadTimeAggregate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_download_time_aggregate",
+		Help: "The min, avg, max and p95 of the latest test stream 2-segment download time across regions.",
+	}, []string{"stat"})
+	m.TranscodeTimeAggregate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_transcode_time_aggregate",
+		Help: "The min, avg, max and p95 of the latest test stream 2-segment transcode time across regions.",
+	}, []string{"stat"})
+	m.RoundTripTimeAggregate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_round_trip_time_aggregate",
+		Help: "The min, avg, max and p95 of the latest test stream round trip time across regions.",
+	}, []string{"stat"})
+	m.Latency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_stream_latency_seconds",
+		Help: "The quantiles of the round trip time of all test streams returned by the API.",
+	}, []string{"quantile"})
+	m.SuccessRateAvg = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "livepeer_orch_test_streams_success_rate_avg",
+		Help: "The average of the latest test stream success rate across regions over the success rate window.",
+	})
 }
 
-// registerMetrics registers the orchestrator test streams metrics with Prometheus.
+// registerMetrics registers the orchestrator test streams metrics with the exporter's registry.
 func (m *TestStreamsExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.SuccessRate,
 		m.UploadTime,
 		m.DownloadTime,
 		m.TranscodeTime,
 		m.RoundTripTime,
+		m.Total,
+		m.Passing,
+		m.RecentUploadTime,
+		m.RecentDownloadTime,
+		m.RecentTranscodeTime,
+		m.Errors,
+		m.SuccessRateAggregate,
+		m.UploadTimeAggregate,
+		m.DownloadTimeAggregate,
+		m.TranscodeTimeAggregate,
+		m.RoundTripTimeAggregate,
+		m.Latency,
+		m.SuccessRateAvg,
 	)
 }
 
 // updateMetrics updates the metrics with the data fetched from the  'interptr-latest-test-streams' API.
+// NOTE: The write lock is held, since the success rate window is written as well.
 func (m *TestStreamsExporter) updateMetrics() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Reset the recent metrics so that test streams beyond the recent results are removed.
+	m.RecentUploadTime.Reset()
+	m.RecentDownloadTime.Reset()
+	m.RecentTranscodeTime.Reset()
+	m.Errors.Reset()
+
+	total, passing := 0, 0
+	var successRates, uploadTimes, downloadTimes, transcodeTimes, roundTripTimes, latencies []float64
 	for _, regionData := range []struct {
 		Region      string
 		testStreams []testStreams
@@ -114,22 +304,118 @@ func (m *TestStreamsExporter) updateMetrics() {
 		{"SAO", m.orchTestStreams.SAO},
 		{"SIN", m.orchTestStreams.SIN},
 	} {
+		if len(regionData.testStreams) == 0 {
+			continue
+		}
+
 		// Only use the first test stream data since it is the most recent.
-		m.SuccessRate.WithLabelValues(regionData.Region, regionData.testStreams[0].Orchestrator).Set(regionData.testStreams[0].SuccessRate)
-		m.UploadTime.WithLabelValues(regionData.Region, regionData.testStreams[0].Orchestrator).Set(regionData.testStreams[0].UploadTime)
-		m.DownloadTime.WithLabelValues(regionData.Region, regionData.testStreams[0].Orchestrator).Set(regionData.testStreams[0].DownloadTime)
-		m.TranscodeTime.WithLabelValues(regionData.Region, regionData.testStreams[0].Orchestrator).Set(regionData.testStreams[0].TranscodeTime)
-		m.RoundTripTime.WithLabelValues(regionData.Region, regionData.testStreams[0].Orchestrator).Set(regionData.testStreams[0].RoundTripTime)
+		// NOTE: When aggregating, the per-region and recent metrics are skipped and the values are
+		// collected for the aggregates instead.
+		latest := regionData.testStreams[0]
+		total++
+		if latest.SuccessRate >= m.successThreshold {
+			passing++
+		}
+		successRates = append(successRates, latest.SuccessRate)
+		if m.aggregateMetrics {
+			uploadTimes = append(uploadTimes, latest.UploadTime)
+			downloadTimes = append(downloadTimes, latest.DownloadTime)
+			transcodeTimes = append(transcodeTimes, latest.TranscodeTime)
+			roundTripTimes = append(roundTripTimes, latest.RoundTripTime)
+		} else {
+			m.SuccessRate.WithLabelValues(regionData.Region, latest.Orchestrator).Set(latest.SuccessRate)
+			m.UploadTime.WithLabelValues(regionData.Region, latest.Orchestrator).Set(latest.UploadTime)
+			m.DownloadTime.WithLabelValues(regionData.Region, latest.Orchestrator).Set(latest.DownloadTime)
+			m.TranscodeTime.WithLabelValues(regionData.Region, latest.Orchestrator).Set(latest.TranscodeTime)
+			m.RoundTripTime.WithLabelValues(regionData.Region, latest.Orchestrator).Set(latest.RoundTripTime)
+		}
+
+		// Set the recent metrics of the most recent test streams.
+		// NOTE: The API only returns the 2-segment totals of each test stream and no per-segment data.
+		recentResults := m.recentResults
+		if m.aggregateMetrics {
+			recentResults = 0
+		}
+		for i, stream := range regionData.testStreams[:min(recentResults, len(regionData.testStreams))] {
+			index := strconv.Itoa(i)
+			m.RecentUploadTime.WithLabelValues(regionData.Region, stream.Orchestrator, index).Set(stream.UploadTime)
+			m.RecentDownloadTime.WithLabelValues(regionData.Region, stream.Orchestrator, index).Set(stream.DownloadTime)
+			m.RecentTranscodeTime.WithLabelValues(regionData.Region, stream.Orchestrator, index).Set(stream.TranscodeTime)
+		}
+
+		// Count the errors of all test streams of the region by type and collect their latencies.
+		errors := make(map[string]int)
+		for _, stream := range regionData.testStreams {
+			latencies = append(latencies, stream.RoundTripTime)
+			for _, err := range stream.Errors {
+				errors[errorType(string(err))]++
+			}
+		}
+		for _, t := range ErrorTypes {
+			m.Errors.WithLabelValues(regionData.Region, t).Set(float64(errors[t]))
+		}
+	}
+
+	// Set the aggregates across regions.
+	if m.aggregateMetrics && len(successRates) > 0 {
+		for vec, values := range map[*prometheus.GaugeVec][]float64{
+			m.SuccessRateAggregate:   successRates,
+			m.UploadTimeAggregate:    uploadTimes,
+			m.DownloadTimeAggregate:  downloadTimes,
+			m.TranscodeTimeAggregate: transcodeTimes,
+			m.RoundTripTimeAggregate: roundTripTimes,
+		} {
+			for stat, value := range aggregate(values) {
+				vec.WithLabelValues(stat).Set(value)
+			}
+		}
+	}
+
+	// Set the latency quantiles across all test streams.
+	// NOTE: The API only returns a snapshot of the most recent test streams, so the quantiles are
+	// calculated over that snapshot with the nearest-rank method instead of being accumulated over
+	// time like a Prometheus summary.
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		for _, q := range LatencyQuantiles {
+			m.Latency.WithLabelValues(strconv.FormatFloat(q, 'f', -1, 64)).Set(quantile(latencies, q))
+		}
+	}
+
+	// Set the success rate average over the window.
+	// NOTE: Each fetch is only added once, so that the average is independent of the update interval.
+	if total > 0 && m.fetchedAt.After(m.prevFetchedAt) {
+		var sum float64
+		for _, rate := range successRates {
+			sum += rate
+		}
+		m.successRateWindow.Add(m.fetchedAt, sum/float64(total))
+		m.prevFetchedAt = m.fetchedAt
 	}
+	if avg, ok := m.successRateWindow.Average(); ok {
+		m.SuccessRateAvg.Set(avg)
+	}
+
+	m.Total.Set(float64(total))
+	m.Passing.Set(float64(passing))
 }
 
-// NewOrchTestStreamsExporter creates a new TestStreamsExporter.
-func NewOrchTestStreamsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration) *TestStreamsExporter {
+// NewOrchTestStreamsExporter creates a new TestStreamsExporter. Test streams whose success rate
+// is at least successThreshold are counted as passing. The recent metrics cover the recentResults
+// most recent test streams of each region. When aggregateMetrics is set, only aggregates across
+// regions are exposed instead of the per-region and recent metrics. The success rate average is
+// calculated over successRateWindow. The endpointTemplate is formatted with the orchestrator address.
+func NewOrchTestStreamsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, successThreshold float64, recentResults int, aggregateMetrics bool, successRateWindow time.Duration, endpointTemplate string, client *http.Client) *TestStreamsExporter {
 	exporter := &TestStreamsExporter{
 		fetchInterval:           fetchInterval,
 		updateInterval:          updateInterval,
-		orchTestStreamsEndpoint: fmt.Sprintf(orchDelegatorsEndpointTemplate, orchAddress),
+		clock:                   runner.RealClock,
+		orchTestStreamsEndpoint: fmt.Sprintf(endpointTemplate, orchAddress),
+		successThreshold:        successThreshold,
+		recentResults:           recentResults,
+		aggregateMetrics:        aggregateMetrics,
 		orchTestStreams:         &orchTestStreams{},
+		successRateWindow:       util.NewRollingWindow(successRateWindow),
 	}
 
 	// Create request headers.
@@ -140,8 +426,8 @@ func NewOrchTestStreamsExporter(orchAddress string, fetchInterval time.Duration,
 	// Initialize fetcher.
 	exporter.orchTestStreamsFetcher = fetcher.Fetcher{
 		URL:     exporter.orchTestStreamsEndpoint,
-		Data:    &exporter.orchTestStreams,
 		Headers: headers,
+		Client:  client,
 	}
 
 	// Initialize metrics.
@@ -151,33 +437,40 @@ func NewOrchTestStreamsExporter(orchAddress string, fetchInterval time.Duration,
 	return exporter
 }
 
-// Start starts the TestStreamsExporter.
-func (m *TestStreamsExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchTestStreamsFetcher.FetchData()
-	m.updateMetrics()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+func (m *TestStreamsExporter) fetchData() bool {
+	response := &orchTestStreams{}
+	m.orchTestStreamsFetcher.Data = response
+	if err := m.orchTestStreamsFetcher.FetchData(); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator test streams data: %v", exporterName, err)
+		return false
+	}
+
+	m.mu.Lock()
+	m.orchTestStreams = response
+	m.fetchedAt = m.clock.Now()
+	m.mu.Unlock()
+	return true
+}
 
-	// Start fetcher in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *TestStreamsExporter) Fetch() bool {
+	return m.fetchData()
+}
 
-		for range ticker.C {
-			m.orchTestStreams.Mutex.Lock()
-			m.orchTestStreamsFetcher.FetchData()
-			m.orchTestStreams.Mutex.Unlock()
-		}
-	}()
+// Name returns the name that identifies the TestStreamsExporter in logs and metrics.
+func (m *TestStreamsExporter) Name() string {
+	return exporterName
+}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+// Gatherer returns the registry the TestStreamsExporter's metrics are registered with.
+func (m *TestStreamsExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
 
-		for range ticker.C {
-			m.orchTestStreams.Mutex.Lock()
-			m.updateMetrics()
-			m.orchTestStreams.Mutex.Unlock()
-		}
-	}()
+// Start starts the TestStreamsExporter and blocks until ctx is cancelled.
+func (m *TestStreamsExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
@@ -0,0 +1,124 @@
+package orch_test_streams_exporter
+
+import (
+	"livepeer-exporter/testutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestExporter returns a TestStreamsExporter that fetches the test streams from server and
+// exposes the given number of recent test streams per region.
+func newTestExporter(server *testutil.Server, recentResults int, aggregateMetrics bool) *TestStreamsExporter {
+	return NewOrchTestStreamsExporter(testutil.OrchAddress, time.Minute, time.Minute, 0.9, recentResults, aggregateMetrics, time.Hour, server.URL+"/streams?orchestrator=%s", server.Client())
+}
+
+// streamsRoutes returns the routes that serve the test streams fixture.
+func streamsRoutes() []testutil.Route {
+	return []testutil.Route{{Path: "/streams", Fixture: "orch_test_streams.json"}}
+}
+
+func TestRecentMetrics(t *testing.T) {
+	tests := []struct {
+		name             string
+		recentResults    int
+		aggregateMetrics bool
+		wantSeries       int
+	}{
+		{"all results", 5, false, 3},
+		{"capped", 1, false, 2},
+		{"disabled", 0, false, 0},
+		{"aggregated", 5, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewServer(t, streamsRoutes()...)
+			exporter := newTestExporter(server, tt.recentResults, tt.aggregateMetrics)
+			if !exporter.fetchData() {
+				t.Fatal("fetchData() failed")
+			}
+			exporter.updateMetrics()
+
+			for name, vec := range map[string]*prometheus.GaugeVec{
+				"livepeer_orch_test_stream_recent_upload_time":    exporter.RecentUploadTime,
+				"livepeer_orch_test_stream_recent_download_time":  exporter.RecentDownloadTime,
+				"livepeer_orch_test_stream_recent_transcode_time": exporter.RecentTranscodeTime,
+			} {
+				if got := testutil.Count(vec); got != tt.wantSeries {
+					t.Errorf("%s has %d series, want %d", name, got, tt.wantSeries)
+				}
+			}
+		})
+	}
+}
+
+func TestRecentMetricsIndex(t *testing.T) {
+	server := testutil.NewServer(t, streamsRoutes()...)
+	exporter := newTestExporter(server, 5, false)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	// The index is the position of the test stream in the results of the region, newest first.
+	for index, want := range map[string]float64{"0": 0.12, "1": 0.14} {
+		gauge := exporter.RecentUploadTime.WithLabelValues("FRA", testutil.OrchAddress, index)
+		if got := testutil.Value(t, gauge); got != want {
+			t.Errorf("livepeer_orch_test_stream_recent_upload_time{index=%q} = %v, want %v", index, got, want)
+		}
+	}
+}
+
+func TestUpdateMetricsConcurrently(t *testing.T) {
+	server := testutil.NewServer(t, streamsRoutes()...)
+	exporter := newTestExporter(server, 5, false)
+	exporter.clock = testutil.NewFakeClock(time.Unix(1704067200, 0))
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+
+	// NOTE: The first update adds the fetch to the success rate window, which must not race with the
+	// other updates of the same fetch.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exporter.updateMetrics()
+		}()
+	}
+	wg.Wait()
+
+	if got := testutil.Value(t, exporter.SuccessRateAvg); got != 1 {
+		t.Errorf("livepeer_orch_test_streams_success_rate_avg = %v, want 1", got)
+	}
+}
+
+func TestSuccessRateAvgCountsEachFetchOnce(t *testing.T) {
+	server := testutil.NewServer(t, streamsRoutes()...)
+	exporter := newTestExporter(server, 5, false)
+	clock := testutil.NewFakeClock(time.Unix(1704067200, 0))
+	exporter.clock = clock
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	// The latest FRA test stream fails half of the time, which halves its success rate.
+	degraded := strings.Replace(string(testutil.Fixture(t, "orch_test_streams.json")), `"success_rate": 1,`, `"success_rate": 0.5,`, 1)
+	server.SetRoutes(t, testutil.Route{Path: "/streams", Body: degraded})
+	clock.Advance(time.Minute)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	for i := 0; i < 3; i++ {
+		exporter.updateMetrics()
+	}
+
+	if got := testutil.Value(t, exporter.SuccessRateAvg); got != 0.875 {
+		t.Errorf("livepeer_orch_test_streams_success_rate_avg = %v, want 0.875", got)
+	}
+}
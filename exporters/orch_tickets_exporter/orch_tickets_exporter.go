@@ -4,9 +4,16 @@
 package orch_tickets_exporter
 
 import (
+	"context"
 	"fmt"
 	"livepeer-exporter/constants"
 	"livepeer-exporter/fetcher"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -14,14 +21,26 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	winningTicketRedeemedEventsEndpoint = constants.LivePeerSubgraphEndpoint
-)
+// exporterName identifies the exporter in logs and metrics.
+const exporterName = "orch_tickets"
+
+// maxWinProb is the win probability of a ticket that always wins (2^256 - 1).
+var maxWinProb = new(big.Float).SetInt(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)))
+
+// maxPriceFetches is the maximum number of historical ETH prices fetched per fetch, so that a long
+// ticket history does not flood the price API on startup. The remaining prices are fetched on the
+// following fetches.
+const maxPriceFetches = 30
+
+// recentTickets is the number of most recent tickets fetched per fetch.
+// NOTE: The subgraph returns the first 100 tickets by ID when no order and limit are given, so the
+// tickets are fetched newest first to keep the most recent tickets in the metrics.
+const recentTickets = 1000
 
 // graphqlQuery represents the GraphQL query to fetch data from the GraphQL API.
 const graphqlQueryTemplate = `
 {
-	winningTicketRedeemedEvents(where: {recipient: "%s"}) {
+	winningTicketRedeemedEvents(where: {recipient: "%s"}, orderBy: timestamp, orderDirection: desc, first: %d) {
 		transaction {
 			gasUsed
 			gasPrice
@@ -32,16 +51,48 @@ const graphqlQueryTemplate = `
 		round {
 			id
 		}
+		sender {
+			id
+		}
 		faceValue
+		winProb
+	}
+	protocol(id: "0") {
+		currentRound {
+			id
+		}
+	}
+	_meta {
+		block {
+			number
+		}
+		hasIndexingErrors
 	}
 }
 `
 
+// activeSendersQueryTemplate represents the GraphQL query to fetch a page of the orchestrator's
+// tickets that were redeemed at or after the given timestamp, starting after the given ticket ID.
+const activeSendersQueryTemplate = `
+{
+	winningTicketRedeemedEvents(where: {recipient: "%s", timestamp_gte: %d, id_gt: "%s"}, orderBy: id, orderDirection: asc, first: %d) {
+		id
+		timestamp
+		sender {
+			id
+		}
+	}
+}
+`
+
+// activeSendersPageSize is the number of tickets fetched per active senders query.
+const activeSendersPageSize = 1000
+
 // winningTicketRedeemedEvent represents the structure of the winningTicketRedeemedEvent field contained in the GraphQL API response.
 type winningTicketRedeemedEvent struct {
 	Transaction struct {
-		GasUsed     string
-		GasPrice    string
+		GasUsed     util.Numeric
+		GasPrice    util.Numeric
 		BlockNumber string
 		Timestamp   int
 		ID          string
@@ -49,17 +100,59 @@ type winningTicketRedeemedEvent struct {
 	Round struct {
 		ID string
 	}
-	FaceValue string
+	Sender struct {
+		ID string
+	}
+	FaceValue util.Numeric
+	WinProb   util.Numeric
 }
 
 // winningTicketRedeemedResponse represents the structure of the GraphQL API response.
 type winningTicketRedeemedResponse struct {
-	sync.Mutex
-
-	// Response data.
 	Data struct {
 		WinningTicketRedeemedEvents []winningTicketRedeemedEvent
+		Protocol                    struct {
+			CurrentRound struct {
+				ID string
+			}
+		}
+	}
+}
+
+// activeSendersResponse represents the structure of the active senders GraphQL API response.
+type activeSendersResponse struct {
+	Data struct {
+		WinningTicketRedeemedEvents []struct {
+			ID        string
+			Timestamp int
+			Sender    struct {
+				ID string
+			}
+		}
+	}
+}
+
+// ethUSDPriceResponse represents the structure of the ETH price API response.
+type ethUSDPriceResponse struct {
+	Data struct {
+		Amount util.Numeric
+	}
+}
+
+// ticketDate returns the UTC date on which a ticket was redeemed in the 'YYYY-MM-DD' format.
+func ticketDate(ticket winningTicketRedeemedEvent) string {
+	return time.Unix(int64(ticket.Transaction.Timestamp), 0).UTC().Format(time.DateOnly)
+}
+
+// parseWinProb parses the win probability of a ticket, which the ticket broker encodes as an
+// integer out of 2^256 - 1, to a probability between 0 and 1.
+func parseWinProb(winProb util.Numeric) (float64, bool) {
+	value, ok := new(big.Int).SetString(string(winProb), 10)
+	if !ok {
+		return 0, false
 	}
+	prob, _ := new(big.Float).Quo(new(big.Float).SetInt(value), maxWinProb).Float64()
+	return prob, true
 }
 
 // OrchTicketsExporter fetches data from the API and exposes orchestrator's tickets metrics via Prometheus.
@@ -84,19 +177,39 @@ type OrchTicketsExporter struct {
 	NinetyDayGasCost         prometheus.Gauge
 	YearGasCost              prometheus.Gauge
 	TotalGasCost             prometheus.Gauge
+	FeesUSDHistorical        prometheus.Gauge
+	FeesUSDMissingPrices     prometheus.Gauge
+	ActiveSenders            prometheus.Gauge
+	CurrentRoundFees         prometheus.Gauge
+	TicketFaceValue          *prometheus.GaugeVec
+	TicketWinProb            *prometheus.GaugeVec
+	TicketEV                 *prometheus.GaugeVec
+	TicketsRedeemed          prometheus.Counter
+	SinceLastRedemption      *prometheus.GaugeVec
+	registry                 *prometheus.Registry // The registry the metrics are registered with.
 
 	// Config settings.
-	orchAddress             string        // The orchestrator address to filter tickets by.
-	fetchInterval           time.Duration // How often to fetch data.
-	updateInterval          time.Duration // How often to update metrics.
-	orchTicketsEndpoint     string        // The endpoint to fetch data from.
-	orchTicketsGraphqlQuery string        // The GraphQL query to fetch data from the GraphQL API.
+	orchAddress             string                 // The orchestrator address to filter tickets by.
+	fetchInterval           time.Duration          // How often to fetch data.
+	updateInterval          time.Duration          // How often to update metrics.
+	clock                   runner.Clock           // The clock that drives the fetch and update loops.
+	orchTicketsEndpoint     string                 // The endpoint to fetch data from.
+	orchTicketsGraphqlQuery string                 // The GraphQL query to fetch data from the GraphQL API.
+	historicalPriceEndpoint string                 // The endpoint template to fetch the ETH price of a date from.
+	currentETHUSDPrice      func() (float64, bool) // Returns the current ETH price in USD, used when historical prices are not configured.
+	activeSendersWindow     time.Duration          // The window in which a sender must have sent a winning ticket to count as active.
 
 	// Data.
-	orchTickets *winningTicketRedeemedResponse // The data returned by the API.
+	mu            sync.RWMutex                   // Guards the data returned by the API.
+	orchTickets   *winningTicketRedeemedResponse // The data returned by the API.
+	activeSenders map[string]int                 // The newest redeem time of each sender that redeemed a ticket in the active senders window.
+	ethUSDPrices  map[string]float64             // The historical ETH prices in USD by date.
+	lastRedeemed  int                            // The timestamp of the newest ticket counted as redeemed. -1 until the first fetch. Only used by fetchData.
 
 	// Fetchers.
-	orchTicketsFetcher fetcher.Fetcher
+	orchTicketsFetcher   fetcher.Fetcher
+	activeSendersFetcher fetcher.Fetcher
+	ethPriceFetcher      fetcher.Fetcher
 }
 
 // initMetrics initializes the orchestrator tickets metrics.
@@ -222,11 +335,71 @@ func (m *OrchTicketsExporter) initMetrics() {
 			Help: "The total gas cost for all ticket redeem transactions.",
 		},
 	)
+	m.FeesUSDHistorical = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_fees_usd_historical",
+			Help: "The total value in USD of the ETH fees won by the orchestrator at the ETH price of the day each ticket was redeemed.",
+		},
+	)
+	m.FeesUSDMissingPrices = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_fees_usd_missing_prices",
+			Help: "The number of winning tickets left out of 'livepeer_orch_fees_usd_historical' since their ETH price is not known yet.",
+		},
+	)
+	m.ActiveSenders = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_active_senders",
+			Help: "The number of distinct broadcasters that sent the orchestrator a redeemed winning ticket in the active senders window.",
+		},
+	)
+	m.CurrentRoundFees = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_current_round_fees",
+			Help: "The amount of ETH fees won by the orchestrator with tickets of the current round.",
+		},
+	)
+	m.TicketFaceValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_ticket_face_value",
+			Help: "The face value in ETH of the last winning ticket redeemed from each sender.",
+		},
+		[]string{"sender"},
+	)
+	m.TicketWinProb = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_ticket_win_prob",
+			Help: "The win probability of the last winning ticket redeemed from each sender.",
+		},
+		[]string{"sender"},
+	)
+	m.TicketEV = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_ticket_ev",
+			Help: "The expected value in ETH of the last winning ticket redeemed from each sender.",
+		},
+		[]string{"sender"},
+	)
+	m.TicketsRedeemed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "livepeer_orch_winning_tickets_redeemed_total",
+			Help: "The number of winning tickets the orchestrator redeemed since the exporter started.",
+		},
+	)
+	// NOTE: A vector without labels is used so that it is only exposed once a ticket was redeemed.
+	m.SinceLastRedemption = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_seconds_since_last_ticket_redemption",
+			Help: "The number of seconds since the orchestrator last redeemed a winning ticket.",
+		},
+		nil,
+	)
 }
 
-// registerMetrics registers the orchestrator tickets metrics with Prometheus.
+// registerMetrics registers the orchestrator tickets metrics with the exporter's registry.
 func (m *OrchTicketsExporter) registerMetrics() {
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
 		m.WinningTicketAmount,
 		m.WinningTicketGasUsed,
 		m.WinningTicketGasPrice,
@@ -246,28 +419,51 @@ func (m *OrchTicketsExporter) registerMetrics() {
 		m.NinetyDayGasCost,
 		m.YearGasCost,
 		m.TotalGasCost,
+		m.FeesUSDHistorical,
+		m.FeesUSDMissingPrices,
+		m.ActiveSenders,
+		m.CurrentRoundFees,
+		m.TicketFaceValue,
+		m.TicketWinProb,
+		m.TicketEV,
+		m.TicketsRedeemed,
+		m.SinceLastRedemption,
 	)
 }
 
 // updateMetrics updates the metrics with the data fetched the Livepeer subgraph GraphQL API.
 func (m *OrchTicketsExporter) updateMetrics() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	// Create required Unix timestamps.
-	now := time.Now()
+	now := m.clock.Now()
 	dayAgo := now.AddDate(0, 0, -1)
 	weekAgo := now.AddDate(0, 0, -7)
 	ThirtyDaysAgo := now.AddDate(0, -1, 0)
 	ninetyDaysAgo := now.AddDate(0, -3, 0)
 	yearAgo := now.AddDate(-1, 0, 0)
+	activeSendersSince := now.Add(-m.activeSendersWindow)
 
 	// Set the metrics for each ticket.
 	var totalFees, totalGasCost float64
 	var dayFees, weekFees, thirtyDayFees, ninetyDayFees, yearFees float64
 	var dayGasCost, weekGasCost, thirtyDayGasCost, ninetyDayGasCost, yearGasCost float64
+	var feesUSD, currentRoundFees float64
+	var missingPrices int
+	currentPrice, currentPriceOK := 0.0, false
+	if m.historicalPriceEndpoint == "" {
+		currentPrice, currentPriceOK = m.currentETHUSDPrice()
+	}
+	currentRound := m.orchTickets.Data.Protocol.CurrentRound.ID
+	lastTickets := make(map[string]winningTicketRedeemedEvent)
+	var lastRedemption int
 	for _, ticket := range m.orchTickets.Data.WinningTicketRedeemedEvents {
-		amount, _ := strconv.ParseFloat(ticket.FaceValue, 64)
-		gasUsed, _ := strconv.ParseFloat(ticket.Transaction.GasUsed, 64)
-		gasPrice, _ := strconv.ParseFloat(ticket.Transaction.GasPrice, 64)
-		gasCost := (gasUsed * gasPrice) / 1e9
+		amount, _ := ticket.FaceValue.Float64()
+		amount = util.RoundTokenAmount(amount)
+		gasUsed, _ := ticket.Transaction.GasUsed.Float64()
+		gasPrice, _ := ticket.Transaction.GasPrice.Float64()
+		gasCost := util.RoundTokenAmount((gasUsed * gasPrice) / 1e9)
 		blockNumber, _ := strconv.ParseFloat(ticket.Transaction.BlockNumber, 64)
 		blockTime, _ := strconv.ParseFloat(strconv.Itoa(ticket.Transaction.Timestamp), 64)
 		round, _ := strconv.ParseFloat(ticket.Round.ID, 64)
@@ -303,6 +499,31 @@ func (m *OrchTicketsExporter) updateMetrics() {
 		}
 		totalFees += amount
 		totalGasCost += gasCost
+
+		// NOTE: The tickets are fetched newest first, so the first ticket of each sender is its last.
+		if _, ok := lastTickets[ticket.Sender.ID]; !ok {
+			lastTickets[ticket.Sender.ID] = ticket
+		}
+
+		// NOTE: The tickets are fetched newest first, so the tickets of the current round are always
+		// included.
+		if currentRound != "" && ticket.Round.ID == currentRound {
+			currentRoundFees += amount
+		}
+		lastRedemption = max(lastRedemption, ticket.Transaction.Timestamp)
+
+		// Value the fees at the ETH price of the redeem date.
+		// NOTE: Falls back to the current price when historical prices are not configured. Tickets whose
+		// price is not known yet are skipped and counted as missing.
+		price, ok := currentPrice, currentPriceOK
+		if m.historicalPriceEndpoint != "" {
+			price, ok = m.ethUSDPrices[ticketDate(ticket)]
+		}
+		if ok {
+			feesUSD += amount * price
+		} else {
+			missingPrices++
+		}
 	}
 
 	// Set the period fees and gas costs.
@@ -318,17 +539,60 @@ func (m *OrchTicketsExporter) updateMetrics() {
 	m.NinetyDayGasCost.Set(ninetyDayGasCost)
 	m.YearGasCost.Set(yearGasCost)
 	m.TotalGasCost.Set(totalGasCost)
+	m.FeesUSDHistorical.Set(feesUSD)
+	m.FeesUSDMissingPrices.Set(float64(missingPrices))
+
+	// Count the senders that redeemed a ticket in the active senders window.
+	var activeSenders int
+	for _, redeemed := range m.activeSenders {
+		if int64(redeemed) >= activeSendersSince.Unix() {
+			activeSenders++
+		}
+	}
+	m.ActiveSenders.Set(float64(activeSenders))
+	m.CurrentRoundFees.Set(currentRoundFees)
+
+	// Set the time since the last redemption.
+	// NOTE: The metric is removed when the orchestrator never redeemed a ticket.
+	m.SinceLastRedemption.Reset()
+	if lastRedemption > 0 {
+		m.SinceLastRedemption.WithLabelValues().Set(max(now.Sub(time.Unix(int64(lastRedemption), 0)).Seconds(), 0))
+	}
+
+	// Set the ticket parameters of the last ticket redeemed from each sender.
+	// NOTE: The metrics are reset so that senders whose tickets are no longer returned are removed.
+	// The win probability and expected value are skipped when the win probability is unknown.
+	m.TicketFaceValue.Reset()
+	m.TicketWinProb.Reset()
+	m.TicketEV.Reset()
+	for sender, ticket := range lastTickets {
+		faceValue, _ := ticket.FaceValue.Float64()
+		m.TicketFaceValue.WithLabelValues(sender).Set(util.RoundTokenAmount(faceValue))
+		if winProb, ok := parseWinProb(ticket.WinProb); ok {
+			m.TicketWinProb.WithLabelValues(sender).Set(winProb)
+			m.TicketEV.WithLabelValues(sender).Set(util.RoundTokenAmount(faceValue * winProb))
+		}
+	}
 }
 
-// NewOrchTicketsExporter creates a new OrchTicketsExporter.
-func NewOrchTicketsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration) *OrchTicketsExporter {
+// NewOrchTicketsExporter creates a new OrchTicketsExporter. The historicalPriceEndpoint is formatted
+// with a 'YYYY-MM-DD' date. When it is empty, fees are valued at the current ETH price returned by
+// currentETHUSDPrice instead.
+func NewOrchTicketsExporter(orchAddress string, fetchInterval time.Duration, updateInterval time.Duration, historicalPriceEndpoint string, currentETHUSDPrice func() (float64, bool), activeSendersWindow time.Duration, endpoint string, client *http.Client) *OrchTicketsExporter {
 	exporter := &OrchTicketsExporter{
 		orchAddress:             orchAddress,
 		fetchInterval:           fetchInterval,
 		updateInterval:          updateInterval,
-		orchTicketsEndpoint:     winningTicketRedeemedEventsEndpoint,
-		orchTicketsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress),
+		clock:                   runner.RealClock,
+		orchTicketsEndpoint:     endpoint,
+		orchTicketsGraphqlQuery: fmt.Sprintf(graphqlQueryTemplate, orchAddress, recentTickets),
+		historicalPriceEndpoint: historicalPriceEndpoint,
+		currentETHUSDPrice:      currentETHUSDPrice,
+		activeSendersWindow:     activeSendersWindow,
 		orchTickets:             &winningTicketRedeemedResponse{},
+		activeSenders:           make(map[string]int),
+		lastRedeemed:            -1,
+		ethUSDPrices:            make(map[string]float64),
 	}
 
 	// Create request headers.
@@ -339,8 +603,16 @@ func NewOrchTicketsExporter(orchAddress string, fetchInterval time.Duration, upd
 	// Initialize fetcher.
 	exporter.orchTicketsFetcher = fetcher.Fetcher{
 		URL:     exporter.orchTicketsEndpoint,
-		Data:    &exporter.orchTickets,
 		Headers: headers,
+		Client:  client,
+	}
+	exporter.activeSendersFetcher = fetcher.Fetcher{
+		URL:     exporter.orchTicketsEndpoint,
+		Headers: headers,
+		Client:  client,
+	}
+	exporter.ethPriceFetcher = fetcher.Fetcher{
+		Client: client,
 	}
 
 	// Initialize metrics.
@@ -350,33 +622,130 @@ func NewOrchTicketsExporter(orchAddress string, fetchInterval time.Duration, upd
 	return exporter
 }
 
-// Start starts the OrchTicketsExporter.
-func (m *OrchTicketsExporter) Start() {
-	// Fetch initial data and update metrics.
-	m.orchTicketsFetcher.FetchGraphQLData(m.orchTicketsGraphqlQuery)
-	m.updateMetrics()
-
-	// Start fetcher in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.fetchInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			m.orchTickets.Mutex.Lock()
-			m.orchTicketsFetcher.FetchGraphQLData(m.orchTicketsGraphqlQuery)
-			m.orchTickets.Mutex.Unlock()
+// fetchData fetches the latest data from the API and publishes it for the metrics updater.
+// The data is fetched into a new struct so that the lock is only held while swapping it in.
+// It reports whether the data was fetched.
+// NOTE: Nothing is published when any of the active senders pages fails to fetch, so that an
+// incomplete set of senders is not exposed.
+func (m *OrchTicketsExporter) fetchData() bool {
+	response := &winningTicketRedeemedResponse{}
+	m.orchTicketsFetcher.Data = response
+	if err := m.orchTicketsFetcher.FetchGraphQLData(m.orchTicketsGraphqlQuery); err != nil {
+		log.Printf("%s exporter: error fetching orchestrator tickets data: %v", exporterName, err)
+		return false
+	}
+	activeSenders, ok := m.fetchActiveSenders()
+	if !ok {
+		return false
+	}
+
+	m.countRedeemed(response.Data.WinningTicketRedeemedEvents)
+
+	m.mu.Lock()
+	m.orchTickets = response
+	m.activeSenders = activeSenders
+	m.mu.Unlock()
+
+	m.fetchPrices(response.Data.WinningTicketRedeemedEvents)
+	return true
+}
+
+// fetchActiveSenders pages through the tickets redeemed in the active senders window and returns the
+// newest redeem time of each sender. It reports whether all pages were fetched.
+func (m *OrchTicketsExporter) fetchActiveSenders() (map[string]int, bool) {
+	since := m.clock.Now().Add(-m.activeSendersWindow).Unix()
+	senders := make(map[string]int)
+	lastID := ""
+	for {
+		response := &activeSendersResponse{}
+		m.activeSendersFetcher.Data = response
+		query := fmt.Sprintf(activeSendersQueryTemplate, m.orchAddress, since, lastID, activeSendersPageSize)
+		if err := m.activeSendersFetcher.FetchGraphQLData(query); err != nil {
+			log.Printf("%s exporter: error fetching orchestrator active senders data: %v", exporterName, err)
+			return nil, false
+		}
+
+		tickets := response.Data.WinningTicketRedeemedEvents
+		for _, ticket := range tickets {
+			senders[ticket.Sender.ID] = max(senders[ticket.Sender.ID], ticket.Timestamp)
+		}
+		if len(tickets) < activeSendersPageSize {
+			return senders, true
 		}
-	}()
+		lastID = tickets[len(tickets)-1].ID
+	}
+}
+
+// countRedeemed counts the tickets that were redeemed after the newest ticket counted so far, with
+// the transaction hash as exemplar. The first fetch only establishes the baseline.
+func (m *OrchTicketsExporter) countRedeemed(tickets []winningTicketRedeemedEvent) {
+	newest := max(m.lastRedeemed, 0)
+	for _, ticket := range tickets {
+		if m.lastRedeemed >= 0 && ticket.Transaction.Timestamp > m.lastRedeemed {
+			util.AddWithExemplar(m.TicketsRedeemed, 1, prometheus.Labels{"tx_hash": ticket.Transaction.ID})
+		}
+		newest = max(newest, ticket.Transaction.Timestamp)
+	}
+	m.lastRedeemed = newest
+}
+
+// fetchETHUSDPrice fetches the ETH price in USD from url.
+func (m *OrchTicketsExporter) fetchETHUSDPrice(url string) (float64, error) {
+	response := &ethUSDPriceResponse{}
+	m.ethPriceFetcher.URL = url
+	m.ethPriceFetcher.Data = response
+	if err := m.ethPriceFetcher.FetchData(); err != nil {
+		return 0, err
+	}
+	return response.Data.Amount.Float64()
+}
 
-	// Start metrics updater in a goroutine.
-	go func() {
-		ticker := time.NewTicker(m.updateInterval)
-		defer ticker.Stop()
+// fetchPrices fetches the historical ETH prices needed to value the fees of the tickets in USD. The
+// prices are cached per date, so only the prices of new redeem dates are fetched, newest first and at
+// most maxPriceFetches per call. Nothing is fetched when historical prices are not configured.
+// NOTE: The cache is only written here, so it can be read without holding the lock.
+func (m *OrchTicketsExporter) fetchPrices(tickets []winningTicketRedeemedEvent) {
+	if m.historicalPriceEndpoint == "" {
+		return
+	}
 
-		for range ticker.C {
-			m.orchTickets.Mutex.Lock()
-			m.updateMetrics()
-			m.orchTickets.Mutex.Unlock()
+	var dates []string
+	for _, ticket := range tickets {
+		date := ticketDate(ticket)
+		if _, ok := m.ethUSDPrices[date]; !ok && !slices.Contains(dates, date) {
+			dates = append(dates, date)
 		}
-	}()
+	}
+	slices.Sort(dates)
+	slices.Reverse(dates)
+	for _, date := range dates[:min(len(dates), maxPriceFetches)] {
+		price, err := m.fetchETHUSDPrice(fmt.Sprintf(m.historicalPriceEndpoint, date))
+		if err != nil {
+			log.Printf("%s exporter: error fetching ETH price of %s: %v", exporterName, date, err)
+			return
+		}
+		m.mu.Lock()
+		m.ethUSDPrices[date] = price
+		m.mu.Unlock()
+	}
+}
+
+// Fetch fetches the data once and reports whether it was fetched.
+func (m *OrchTicketsExporter) Fetch() bool {
+	return m.fetchData()
+}
+
+// Name returns the name that identifies the OrchTicketsExporter in logs and metrics.
+func (m *OrchTicketsExporter) Name() string {
+	return exporterName
+}
+
+// Gatherer returns the registry the OrchTicketsExporter's metrics are registered with.
+func (m *OrchTicketsExporter) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Start starts the OrchTicketsExporter and blocks until ctx is cancelled.
+func (m *OrchTicketsExporter) Start(ctx context.Context) {
+	runner.Run(ctx, m.clock, exporterName, m.fetchInterval, m.updateInterval, m.fetchData, m.updateMetrics)
 }
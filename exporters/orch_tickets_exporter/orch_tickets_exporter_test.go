@@ -0,0 +1,360 @@
+package orch_tickets_exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"livepeer-exporter/testutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// noCurrentPrice is a current ETH price source that never knows the price.
+func noCurrentPrice() (float64, bool) {
+	return 0, false
+}
+
+// newTestExporter returns an OrchTicketsExporter that fetches the tickets and historical ETH prices
+// from server.
+func newTestExporter(server *testutil.Server) *OrchTicketsExporter {
+	return NewOrchTicketsExporter(testutil.OrchAddress, time.Minute, time.Minute, server.URL+"/prices?date=%s", noCurrentPrice, 24*time.Hour, server.URL+"/graphql", server.Client())
+}
+
+// ticketsRoutes returns the routes that serve the tickets and ETH price fixtures.
+func ticketsRoutes() []testutil.Route {
+	return []testutil.Route{
+		{Path: "/graphql", Fixture: "orch_tickets.json"},
+		{Path: "/prices", Fixture: "eth_price.json"},
+	}
+}
+
+// newestTicket is the redeem time of the newest ticket returned by withTickets.
+const newestTicket = 1704067800
+
+// withTickets returns the tickets fixture with count tickets of 0.1 ETH, newest first and redeemed
+// every 10 minutes from newestTicket, skipping the first skip tickets. Each ticket is redeemed from one
+// of three senders in turn and in a round that lasts 10 tickets, where the first round is the current
+// round.
+func withTickets(t *testing.T, skip, count int) string {
+	t.Helper()
+
+	var response map[string]any
+	if err := json.Unmarshal(testutil.Fixture(t, "orch_tickets.json"), &response); err != nil {
+		t.Fatal(err)
+	}
+	tickets := make([]map[string]any, count)
+	for j := range tickets {
+		i := skip + j
+		tickets[j] = map[string]any{
+			"id":        fmt.Sprintf("0x%064x-0", i),
+			"timestamp": newestTicket - i*600,
+			"transaction": map[string]any{
+				"gasUsed":     "300000",
+				"gasPrice":    "100000000",
+				"blockNumber": fmt.Sprint(19200050 - i*50),
+				"timestamp":   newestTicket - i*600,
+				"id":          fmt.Sprintf("0x%064x", i),
+			},
+			"round":     map[string]string{"id": fmt.Sprint(3302 - i/10)},
+			"sender":    map[string]string{"id": fmt.Sprintf("0x%040x", i%3)},
+			"faceValue": "0.1",
+			"winProb":   "57896044618658097711785492504343953926634992332820282019728792003956564819967",
+		}
+	}
+	response["data"].(map[string]any)["winningTicketRedeemedEvents"] = tickets
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestFetchAndUpdateConcurrently(t *testing.T) {
+	server := testutil.NewServer(t, ticketsRoutes()...)
+	exporter := newTestExporter(server)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			exporter.fetchData()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			exporter.updateMetrics()
+		}
+	}()
+	wg.Wait()
+	exporter.updateMetrics()
+
+	if got := testutil.Value(t, exporter.TotalFees); got != 0.75 {
+		t.Errorf("livepeer_orch_total_fees = %v, want %v", got, 0.75)
+	}
+	if got := testutil.Value(t, exporter.CurrentRoundFees); got != 0.5 {
+		t.Errorf("livepeer_orch_current_round_fees = %v, want %v", got, 0.5)
+	}
+	if got := testutil.Count(exporter.WinningTicketAmount); got != 2 {
+		t.Errorf("livepeer_orch_winning_ticket_amount has %d tickets, want 2", got)
+	}
+}
+
+func TestCurrentRoundFeesResetOnRoundChange(t *testing.T) {
+	server := testutil.NewServer(t, ticketsRoutes()...)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.CurrentRoundFees); got != 0.5 {
+		t.Fatalf("livepeer_orch_current_round_fees = %v, want %v", got, 0.5)
+	}
+
+	// The next round starts without any tickets, so the fees of the previous round must not carry over.
+	nextRound := strings.Replace(string(testutil.Fixture(t, "orch_tickets.json")), `"currentRound": {
+        "id": "3302"`, `"currentRound": {
+        "id": "3303"`, 1)
+	server.SetRoutes(t, testutil.Route{Path: "/graphql", Body: nextRound}, testutil.Route{Path: "/prices", Fixture: "eth_price.json"})
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.CurrentRoundFees); got != 0 {
+		t.Errorf("livepeer_orch_current_round_fees = %v, want 0", got)
+	}
+	if got := testutil.Value(t, exporter.TotalFees); got != 0.75 {
+		t.Errorf("livepeer_orch_total_fees = %v, want %v", got, 0.75)
+	}
+}
+
+func TestFeesUSDHistoricalMissingPrices(t *testing.T) {
+	server := testutil.NewServer(t,
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Query: map[string]string{"date": "2024-01-01"}, Fixture: "eth_price.json"},
+		testutil.Route{Path: "/prices", Status: http.StatusTooManyRequests},
+	)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	// Only the ticket of 2024-01-01 is valued, since the price of 2023-12-31 failed to fetch.
+	if got, want := testutil.Value(t, exporter.FeesUSDHistorical), 0.5*2350.12; got != want {
+		t.Errorf("livepeer_orch_fees_usd_historical = %v, want %v", got, want)
+	}
+	if got := testutil.Value(t, exporter.FeesUSDMissingPrices); got != 1 {
+		t.Errorf("livepeer_orch_fees_usd_missing_prices = %v, want 1", got)
+	}
+
+	// The missing price is fetched again on the next fetch, while the cached price is not.
+	server.SetRoutes(t, ticketsRoutes()...)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.FeesUSDMissingPrices); got != 0 {
+		t.Errorf("livepeer_orch_fees_usd_missing_prices = %v, want 0", got)
+	}
+	if hits := server.Hits("/prices"); hits != 3 {
+		t.Errorf("price endpoint received %d requests, want 3", hits)
+	}
+}
+
+func TestFeesUSDCurrentPrice(t *testing.T) {
+	server := testutil.NewServer(t, ticketsRoutes()...)
+	price, known := 2000.0, false
+	currentPrice := func() (float64, bool) { return price, known }
+	exporter := NewOrchTicketsExporter(testutil.OrchAddress, time.Minute, time.Minute, "", currentPrice, 24*time.Hour, server.URL+"/graphql", server.Client())
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+
+	// The fees are not valued while the current price is unknown.
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.FeesUSDMissingPrices); got != 2 {
+		t.Errorf("livepeer_orch_fees_usd_missing_prices = %v, want 2", got)
+	}
+
+	known = true
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.FeesUSDHistorical); got != 1500 {
+		t.Errorf("livepeer_orch_fees_usd_historical = %v, want 1500", got)
+	}
+	if got := testutil.Value(t, exporter.FeesUSDMissingPrices); got != 0 {
+		t.Errorf("livepeer_orch_fees_usd_missing_prices = %v, want 0", got)
+	}
+	if hits := server.Hits("/prices"); hits != 0 {
+		t.Errorf("price endpoint received %d requests, want 0", hits)
+	}
+}
+
+func TestSinceLastRedemptionWithManyTickets(t *testing.T) {
+	// NOTE: The route only matches when the most recent tickets are requested.
+	server := testutil.NewServer(t,
+		testutil.Route{Path: "/graphql", Contains: "orderBy: timestamp, orderDirection: desc, first: 1000)", Body: withTickets(t, 0, 150)},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+	)
+	exporter := newTestExporter(server)
+	exporter.clock = testutil.NewFakeClock(time.Unix(newestTicket, 0).Add(time.Hour))
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	if got := testutil.Value(t, exporter.SinceLastRedemption); got != 3600 {
+		t.Errorf("livepeer_orch_seconds_since_last_ticket_redemption = %v, want 3600", got)
+	}
+	if got := testutil.Count(exporter.WinningTicketAmount); got != 150 {
+		t.Errorf("livepeer_orch_winning_ticket_amount has %d tickets, want 150", got)
+	}
+}
+
+// activeSendersPage returns an active senders page with count tickets redeemed at redeemed by sender,
+// whose IDs start after the given index.
+func activeSendersPage(t *testing.T, after, count int, sender string, redeemed int64) string {
+	t.Helper()
+
+	tickets := make([]map[string]any, count)
+	for i := range tickets {
+		tickets[i] = map[string]any{
+			"id":        fmt.Sprintf("0x%064x-0", after+i+1),
+			"timestamp": redeemed,
+			"sender":    map[string]string{"id": sender},
+		}
+	}
+	body, err := json.Marshal(map[string]any{"data": map[string]any{"winningTicketRedeemedEvents": tickets}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestActiveSendersPages(t *testing.T) {
+	now := time.Unix(newestTicket, 0).Add(30 * 24 * time.Hour)
+	since := now.Add(-24 * time.Hour).Unix()
+	lastID := fmt.Sprintf("0x%064x-0", activeSendersPageSize)
+	secondPage := testutil.Route{Path: "/graphql", Contains: fmt.Sprintf(`id_gt: \"%s\"`, lastID), Body: activeSendersPage(t, activeSendersPageSize, 2, "0xsender2", since)}
+	routes := []testutil.Route{
+		{Path: "/graphql", Contains: fmt.Sprintf(`timestamp_gte: %d, id_gt: \"\"`, since), Body: activeSendersPage(t, 0, activeSendersPageSize, "0xsender1", now.Unix()-60)},
+		secondPage,
+		{Path: "/graphql", Fixture: "orch_tickets.json"},
+		{Path: "/prices", Fixture: "eth_price.json"},
+	}
+	server := testutil.NewServer(t, routes...)
+	exporter := newTestExporter(server)
+	exporter.clock = testutil.NewFakeClock(now)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	// The senders of both pages are active, while the sender of the fixture tickets is not, since its
+	// tickets are older than the window.
+	if got := testutil.Value(t, exporter.ActiveSenders); got != 2 {
+		t.Errorf("livepeer_orch_active_senders = %v, want 2", got)
+	}
+
+	// Nothing is published when a page fails to fetch.
+	secondPage.Body, secondPage.Status = "", http.StatusInternalServerError
+	routes[1] = secondPage
+	server.SetRoutes(t, routes...)
+	if exporter.fetchData() {
+		t.Error("fetchData() succeeded while an active senders page failed")
+	}
+	exporter.updateMetrics()
+	if got := testutil.Value(t, exporter.ActiveSenders); got != 2 {
+		t.Errorf("livepeer_orch_active_senders = %v, want 2", got)
+	}
+}
+
+func TestTicketsRedeemedWithManyTickets(t *testing.T) {
+	server := testutil.NewServer(t,
+		testutil.Route{Path: "/graphql", Contains: "orderBy: timestamp, orderDirection: desc, first: 1000)", Body: withTickets(t, 1, 150)},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+	)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	if got := testutil.Value(t, exporter.TicketsRedeemed); got != 0 {
+		t.Fatalf("livepeer_orch_tickets_redeemed_total = %v, want 0", got)
+	}
+
+	// Only the ticket redeemed since the previous fetch is counted.
+	server.SetRoutes(t,
+		testutil.Route{Path: "/graphql", Contains: "orderBy: timestamp, orderDirection: desc, first: 1000)", Body: withTickets(t, 0, 150)},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+	)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	if got := testutil.Value(t, exporter.TicketsRedeemed); got != 1 {
+		t.Errorf("livepeer_orch_tickets_redeemed_total = %v, want 1", got)
+	}
+}
+
+func TestCurrentRoundFeesWithManyTickets(t *testing.T) {
+	server := testutil.NewServer(t,
+		testutil.Route{Path: "/graphql", Contains: "orderBy: timestamp, orderDirection: desc, first: 1000)", Body: withTickets(t, 0, 150)},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+	)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	// The 10 newest tickets were redeemed in the current round.
+	if got := testutil.Value(t, exporter.CurrentRoundFees); math.Abs(got-1) > 1e-9 {
+		t.Errorf("livepeer_orch_current_round_fees = %v, want 1", got)
+	}
+}
+
+func TestLastTicketPerSenderWithManyTickets(t *testing.T) {
+	// Raise the face value of the newest ticket of each of the three senders.
+	var response map[string]any
+	if err := json.Unmarshal([]byte(withTickets(t, 0, 150)), &response); err != nil {
+		t.Fatal(err)
+	}
+	for _, ticket := range response["data"].(map[string]any)["winningTicketRedeemedEvents"].([]any)[:3] {
+		ticket.(map[string]any)["faceValue"] = "0.5"
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := testutil.NewServer(t,
+		testutil.Route{Path: "/graphql", Contains: "orderBy: timestamp, orderDirection: desc, first: 1000)", Body: string(body)},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+	)
+	exporter := newTestExporter(server)
+	if !exporter.fetchData() {
+		t.Fatal("fetchData() failed")
+	}
+	exporter.updateMetrics()
+
+	if got := testutil.Count(exporter.TicketFaceValue); got != 3 {
+		t.Errorf("livepeer_orch_ticket_face_value has %d senders, want 3", got)
+	}
+	for i := 0; i < 3; i++ {
+		sender := fmt.Sprintf("0x%040x", i)
+		if got := testutil.Value(t, exporter.TicketFaceValue.WithLabelValues(sender)); got != 0.5 {
+			t.Errorf("livepeer_orch_ticket_face_value{sender=%q} = %v, want 0.5", sender, got)
+		}
+		if got := testutil.Value(t, exporter.TicketEV.WithLabelValues(sender)); got != 0.25 {
+			t.Errorf("livepeer_orch_ticket_ev{sender=%q} = %v, want 0.25", sender, got)
+		}
+	}
+}
@@ -3,26 +3,180 @@ package fetcher
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"livepeer-exporter/metrics"
+	"livepeer-exporter/util"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// SkipOnIndexingErrors controls whether subgraph responses that report indexing errors are discarded.
+// When enabled, FetchGraphQLData returns ErrSubgraphIndexingErrors and leaves the Data field untouched.
+var SkipOnIndexingErrors bool
+
+// ErrSubgraphIndexingErrors is returned when a subgraph response reports indexing errors and
+// SkipOnIndexingErrors is enabled.
+var ErrSubgraphIndexingErrors = errors.New("subgraph reported indexing errors")
+
+// SubgraphMeta represents the '_meta' field of a subgraph GraphQL API response.
+type SubgraphMeta struct {
+	Block struct {
+		Number int64
+	}
+	HasIndexingErrors bool
+}
+
+// subgraphMetaResponse represents the structure of a GraphQL API response that contains the '_meta' field.
+type subgraphMetaResponse struct {
+	Data struct {
+		Meta *SubgraphMeta `json:"_meta"`
+	}
+}
+
+// ClientConfig contains the settings of the HTTP client created by NewHTTPClient.
+type ClientConfig struct {
+	Timeout               time.Duration  // Time limit for the whole request, including reading the body.
+	DialTimeout           time.Duration  // Time limit for establishing a TCP connection.
+	TLSHandshakeTimeout   time.Duration  // Time limit for the TLS handshake.
+	ResponseHeaderTimeout time.Duration  // Time limit for receiving the response headers after sending the request.
+	ProxyURL              *url.URL       // Proxy to send all requests through. Defaults to the proxy set in the environment.
+	MaxConcurrentRequests int            // The maximum number of requests in flight at once. Zero means unlimited.
+	MaxResponseBytes      int64          // The maximum size of a response body. Larger bodies fail to read. Zero means unlimited.
+	RootCAs               *x509.CertPool // Root CAs to verify upstream certificates with. Defaults to the system root CAs.
+	InsecureSkipVerify    bool           // Whether to skip verifying upstream certificates. Only meant for development.
+}
+
+// NewHTTPClient creates a HTTP client whose connection pool is tuned for repeatedly fetching data
+// from the same few hosts. A single client should be shared by all exporters so that connections,
+// and thereby TLS sessions, are reused. Slow endpoints should use a separate client with a limited
+// number of concurrent requests instead, so that they cannot hold up the other exporters.
+func NewHTTPClient(config ClientConfig) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != nil {
+		proxy = http.ProxyURL(config.ProxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			RootCAs:            config.RootCAs,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		},
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if config.MaxResponseBytes > 0 {
+		roundTripper = &maxBytesTransport{
+			next:     roundTripper,
+			maxBytes: config.MaxResponseBytes,
+		}
+	}
+	if config.MaxConcurrentRequests > 0 {
+		roundTripper = &limitedTransport{
+			next:  roundTripper,
+			slots: make(chan struct{}, config.MaxConcurrentRequests),
+		}
+	}
+
+	return &http.Client{
+		Transport:     roundTripper,
+		CheckRedirect: checkRedirect,
+		Timeout:       config.Timeout,
+	}
+}
+
+// maxRedirects is the maximum number of redirects the HTTP client follows.
+const maxRedirects = 10
+
+// checkRedirect only follows redirects to the host of the original request, so that an upstream API
+// that redirects to a login page or CDN results in an error instead of an unrelated body being parsed.
+// Every redirect is logged.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	original := via[0].URL
+	if req.URL.Host != original.Host {
+		log.Printf("WARNING: refusing redirect from '%s' to different host '%s'", original.Redacted(), req.URL.Redacted())
+		return fmt.Errorf("redirect from '%s' to different host '%s' refused", original.Host, req.URL.Host)
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects from '%s'", maxRedirects, original.Redacted())
+	}
+	log.Printf("Following redirect from '%s' to '%s'", via[len(via)-1].URL.Redacted(), req.URL.Redacted())
+	return nil
+}
+
 // Fetcher fetches JSON data from a specified URL and unmarshals it into a provided struct.
 type Fetcher struct {
-	URL     string      // URL to fetch data from.
-	Data    interface{} // Target struct to unmarshal data into.
-	Headers http.Header // Headers to send with the request.
+	URL          string        // URL to fetch data from.
+	BackupURL    string        // URL FetchData falls back to when fetching from URL fails. Optional.
+	UsingBackup  bool          // Whether the last successful FetchData call fetched from the BackupURL.
+	Data         interface{}   // Target struct to unmarshal data into.
+	Headers      http.Header   // Headers to send with the request.
+	CacheBust    bool          // Whether to add a '_t' query parameter with the current time to bypass CDN caches.
+	Client       *http.Client  // Client to send the request with. Defaults to http.DefaultClient.
+	SubgraphMeta *SubgraphMeta // The subgraph '_meta' of the last GraphQL response, if it was queried.
+}
+
+// client returns the HTTP client the Fetcher sends its requests with.
+func (f *Fetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return http.DefaultClient
 }
 
 // FetchData fetches JSON data from the Fetcher's URL and unmarshals it into the Fetcher's Data field.
-// It returns an error if there was an issue fetching the data, if the HTTP status code is not 200,
-// or if there was an issue decoding the response body.
+// When this fails and a BackupURL is set, the data is fetched from the BackupURL instead. It returns
+// an error if there was an issue fetching the data, if the HTTP status code is not 200, or if there
+// was an issue decoding the response body.
 func (f *Fetcher) FetchData() error {
+	err := f.fetchData(f.URL)
+	if err == nil || f.BackupURL == "" {
+		if err == nil {
+			f.UsingBackup = false
+		}
+		return err
+	}
+
+	if backupErr := f.fetchData(f.BackupURL); backupErr != nil {
+		return fmt.Errorf("%w; backup failed too: %w", err, backupErr)
+	}
+	f.UsingBackup = true
+	return nil
+}
+
+// fetchData fetches JSON data from url and unmarshals it into the Fetcher's Data field.
+func (f *Fetcher) fetchData(url string) error {
 	// Create a new request.
-	req, err := http.NewRequest("GET", f.URL, nil)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return fmt.Errorf("error creating request for '%s': %w", url, err)
+	}
+
+	// Bust CDN caches, if requested.
+	if f.CacheBust {
+		query := req.URL.Query()
+		query.Set("_t", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		req.URL.RawQuery = query.Encode()
 	}
 
 	// Add additional headers, if any.
@@ -34,23 +188,22 @@ func (f *Fetcher) FetchData() error {
 		}
 	}
 
-	// Create a client and send the request.
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Send the request.
+	resp, err := f.client().Do(req)
 	if err != nil {
-		return fmt.Errorf("error fetching data from '%s': %w", f.URL, err)
+		return fmt.Errorf("error fetching data from '%s': %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	// Check the HTTP status code.
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		return fmt.Errorf("received non-200 status code from '%s': %d", url, resp.StatusCode)
 	}
 
 	// Decode the response body directly into the Fetcher's Data field.
 	dec := json.NewDecoder(resp.Body)
 	if err := dec.Decode(&f.Data); err != nil {
-		return fmt.Errorf("error decoding response body from '%s': %w", f.URL, err)
+		return fmt.Errorf("error decoding response body from '%s': %w", url, err)
 	}
 
 	return nil
@@ -58,19 +211,20 @@ func (f *Fetcher) FetchData() error {
 
 // FetchGraphQLData fetches GraphQL data from the Fetcher's URL with the provided query and unmarshals
 // it into the Fetcher's Data field. It returns an error if there was an issue fetching the data, if
-// the HTTP status code is not 200, or if there was an issue decoding the response body.
+// the HTTP status code is not 200, if the response contains GraphQL errors, or if there was an issue
+// decoding the response body.
 func (f *Fetcher) FetchGraphQLData(query string) error {
 	requestBody, err := json.Marshal(map[string]string{
 		"query": query,
 	})
 	if err != nil {
-		return fmt.Errorf("error creating request body: %v", err)
+		return fmt.Errorf("error creating request body for '%s': %w", f.URL, err)
 	}
 
 	// Create a new request with the provided data.
 	req, err := http.NewRequest("POST", f.URL, bytes.NewBuffer(requestBody))
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return fmt.Errorf("error creating request for '%s': %w", f.URL, err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -84,18 +238,45 @@ func (f *Fetcher) FetchGraphQLData(query string) error {
 	}
 
 	// Send the request.
-	resp, err := http.DefaultClient.Do(req)
+	// NOTE: Only the request and reading the response are timed, so that the duration reflects the
+	// subgraph rather than the decoding done by the exporter.
+	started := time.Now()
+	resp, err := f.client().Do(req)
 	if err != nil {
-		return fmt.Errorf("error making GraphQL request gtom '%s': %w", f.URL, err)
+		return fmt.Errorf("error making GraphQL request to '%s': %w", f.URL, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		return fmt.Errorf("received non-200 status code from '%s': %d", f.URL, resp.StatusCode)
 	}
 
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&f.Data); err != nil {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body from '%s': %w", f.URL, err)
+	}
+	metrics.SubgraphQueryDurationSeconds.Observe(time.Since(started).Seconds())
+
+	// Check for GraphQL errors, which are returned with a 200 status code.
+	if err := util.CheckGraphQLErrors(body); err != nil {
+		return fmt.Errorf("error fetching data from '%s': %w", f.URL, err)
+	}
+
+	// Check the subgraph indexing status, if it was queried.
+	var metaResponse subgraphMetaResponse
+	if err := json.Unmarshal(body, &metaResponse); err != nil {
+		return fmt.Errorf("error decoding response body from '%s': %w", f.URL, err)
+	}
+	if metaResponse.Data.Meta != nil {
+		f.SubgraphMeta = metaResponse.Data.Meta
+		metrics.SubgraphHasIndexingErrors.Set(util.BoolToFloat64(f.SubgraphMeta.HasIndexingErrors))
+		metrics.SetSubgraphIndexedBlock(f.SubgraphMeta.Block.Number)
+		if f.SubgraphMeta.HasIndexingErrors && SkipOnIndexingErrors {
+			return fmt.Errorf("error fetching data from '%s' at block %d: %w", f.URL, f.SubgraphMeta.Block.Number, ErrSubgraphIndexingErrors)
+		}
+	}
+
+	if err := json.Unmarshal(body, &f.Data); err != nil {
 		return fmt.Errorf("error decoding response body from '%s': %w", f.URL, err)
 	}
 
@@ -0,0 +1,215 @@
+package fetcher
+
+import (
+	"errors"
+	"livepeer-exporter/testutil"
+	"livepeer-exporter/util"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ethPrice represents the structure of the ETH price fixture.
+type ethPrice struct {
+	Data struct {
+		Amount string
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	proxy := testutil.NewServer(t,
+		testutil.Route{Path: "/prices", Fixture: "eth_price.json"},
+		testutil.Route{Path: "/graphql", Fixture: "orch_tickets.json"},
+	)
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewHTTPClient(ClientConfig{Timeout: 5 * time.Second, ProxyURL: proxyURL})
+
+	// NOTE: The upstream host does not resolve, so the requests only succeed through the proxy.
+	price := &ethPrice{}
+	f := Fetcher{URL: "http://upstream.invalid/prices", Data: price, Client: client}
+	if err := f.FetchData(); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	if price.Data.Amount != "2350.12" {
+		t.Errorf("amount = %q, want %q", price.Data.Amount, "2350.12")
+	}
+
+	graphql := Fetcher{URL: "http://upstream.invalid/graphql", Data: &struct{}{}, Client: client}
+	if err := graphql.FetchGraphQLData("{ protocol(id: \"0\") { id } }"); err != nil {
+		t.Fatalf("FetchGraphQLData() error = %v", err)
+	}
+
+	if hits := proxy.Hits("/prices") + proxy.Hits("/graphql"); hits != 2 {
+		t.Errorf("proxy received %d requests, want 2", hits)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	var otherHits atomic.Int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherHits.Add(1)
+		w.Write(testutil.Fixture(t, "eth_price.json"))
+	}))
+	defer other.Close()
+
+	var loopHits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/prices", http.StatusFound)
+		case "/prices":
+			w.Write(testutil.Fixture(t, "eth_price.json"))
+		case "/login":
+			http.Redirect(w, r, other.URL+"/prices", http.StatusFound)
+		case "/loop":
+			loopHits.Add(1)
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPClient(ClientConfig{Timeout: 5 * time.Second})
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"same host", "/moved", ""},
+		{"different host", "/login", "to different host"},
+		{"too many redirects", "/loop", "stopped after 10 redirects"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price := &ethPrice{}
+			f := Fetcher{URL: server.URL + tt.path, Data: price, Client: client}
+			err := f.FetchData()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("FetchData() error = %v", err)
+				}
+				if price.Data.Amount != "2350.12" {
+					t.Errorf("amount = %q, want %q", price.Data.Amount, "2350.12")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchData() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if hits := otherHits.Load(); hits != 0 {
+		t.Errorf("other host received %d requests, want 0", hits)
+	}
+	if hits := loopHits.Load(); hits != maxRedirects {
+		t.Errorf("redirect loop received %d requests, want %d", hits, maxRedirects)
+	}
+}
+
+func TestFetchDataBackup(t *testing.T) {
+	server := testutil.NewServer(t)
+	tests := []struct {
+		name            string
+		routes          []testutil.Route
+		wantErr         []string
+		wantUsingBackup bool
+	}{
+		{
+			name:   "primary",
+			routes: []testutil.Route{{Path: "/primary", Fixture: "eth_price.json"}, {Path: "/backup", Status: http.StatusInternalServerError}},
+		},
+		{
+			name:            "backup",
+			routes:          []testutil.Route{{Path: "/primary", Status: http.StatusBadGateway}, {Path: "/backup", Fixture: "eth_price.json"}},
+			wantUsingBackup: true,
+		},
+		{
+			name:    "both failing",
+			routes:  []testutil.Route{{Path: "/primary", Status: http.StatusBadGateway}, {Path: "/backup", Status: http.StatusServiceUnavailable}},
+			wantErr: []string{"/primary': 502", "backup failed too", "/backup': 503"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.SetRoutes(t, tt.routes...)
+			price := &ethPrice{}
+			f := Fetcher{URL: server.URL + "/primary", BackupURL: server.URL + "/backup", Data: price, Client: server.Client()}
+			err := f.FetchData()
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("FetchData() succeeded, want error")
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("FetchData() error = %v, want error containing %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchData() error = %v", err)
+			}
+			if price.Data.Amount != "2350.12" {
+				t.Errorf("amount = %q, want %q", price.Data.Amount, "2350.12")
+			}
+			if f.UsingBackup != tt.wantUsingBackup {
+				t.Errorf("UsingBackup = %v, want %v", f.UsingBackup, tt.wantUsingBackup)
+			}
+		})
+	}
+}
+
+func TestFetchDataKeepsUsingBackupOnFailure(t *testing.T) {
+	server := testutil.NewServer(t, testutil.Route{Path: "/primary", Status: http.StatusBadGateway}, testutil.Route{Path: "/backup", Fixture: "eth_price.json"})
+	f := Fetcher{URL: server.URL + "/primary", BackupURL: server.URL + "/backup", Data: &ethPrice{}, Client: server.Client()}
+	if err := f.FetchData(); err != nil || !f.UsingBackup {
+		t.Fatalf("FetchData() error = %v, UsingBackup = %v, want backup to be used", err, f.UsingBackup)
+	}
+
+	// A failed fetch does not change which URL the last successful fetch used.
+	server.SetRoutes(t, testutil.Route{Status: http.StatusBadGateway})
+	if err := f.FetchData(); err == nil {
+		t.Fatal("FetchData() succeeded, want error")
+	}
+	if !f.UsingBackup {
+		t.Error("UsingBackup = false after failed fetch, want true")
+	}
+
+	// Recovering the primary switches back to it.
+	server.SetRoutes(t, testutil.Route{Path: "/primary", Fixture: "eth_price.json"})
+	if err := f.FetchData(); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	if f.UsingBackup {
+		t.Error("UsingBackup = true after primary recovered, want false")
+	}
+	if hits := server.Hits("/backup"); hits != 2 {
+		t.Errorf("backup received %d requests, want 2", hits)
+	}
+}
+
+func TestFetchGraphQLDataErrors(t *testing.T) {
+	server := testutil.NewServer(t, testutil.Route{Body: `{"data":{"protocol":{"id":"0"}},"errors":[{"message":"store error"}]}`})
+
+	// NOTE: The partial data of a response with errors must not be used.
+	var data struct {
+		Data struct {
+			Protocol *struct{ ID string }
+		}
+	}
+	f := Fetcher{URL: server.URL, Data: &data, Client: server.Client()}
+	err := f.FetchGraphQLData("{ protocol(id: \"0\") { id } }")
+	if !errors.Is(err, util.ErrGraphQLErrors) {
+		t.Errorf("FetchGraphQLData() error = %v, want error wrapping util.ErrGraphQLErrors", err)
+	}
+	if data.Data.Protocol != nil {
+		t.Errorf("FetchGraphQLData() decoded %+v, want no data", data.Data.Protocol)
+	}
+}
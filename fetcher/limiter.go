@@ -0,0 +1,91 @@
+package fetcher
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// limitedTransport is a http.RoundTripper that limits the number of concurrent requests. A request
+// holds its slot until its response body is closed, so that slow bodies count towards the limit.
+type limitedTransport struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+// releasingBody is a response body that releases the request's slot when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the body and releases the request's slot.
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// RoundTrip waits for a free slot and sends the request. It gives up waiting when the request's
+// context is done.
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	release := sync.OnceFunc(func() { <-t.slots })
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// maxBytesTransport is a http.RoundTripper that limits the size of response bodies, so that a
+// misbehaving endpoint cannot exhaust the memory while its response is decoded.
+type maxBytesTransport struct {
+	next     http.RoundTripper
+	maxBytes int64
+}
+
+// maxBytesBody is a response body that returns an error once more than maxBytes are read, instead
+// of silently truncating the body.
+type maxBytesBody struct {
+	io.ReadCloser
+	remaining int64
+	maxBytes  int64
+	url       string
+}
+
+// Read reads from the body until maxBytes are read. It then returns an error if the body contains
+// more data.
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var probe [1]byte
+		if n, err := b.ReadCloser.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("response body from '%s' exceeds %d bytes", b.url, b.maxBytes)
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// RoundTrip sends the request and limits the size of the response body.
+func (t *maxBytesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &maxBytesBody{ReadCloser: resp.Body, remaining: t.maxBytes, maxBytes: t.maxBytes, url: req.URL.Redacted()}
+	return resp, nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"livepeer-exporter/metrics"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/util"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// landingPageTemplate is the HTML template of the landing page served at '/'.
+var landingPageTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Livepeer Exporter</title></head>
+<body>
+<h1>Livepeer Exporter</h1>
+<p>Version: {{.Version}}</p>
+<ul>
+{{- range .Links}}
+<li><a href="{{$.BasePath}}{{.Path}}">{{$.BasePath}}{{.Path}}</a> - {{.Description}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// landingPageLink represents an endpoint that is listed on the landing page.
+type landingPageLink struct {
+	Path        string
+	Description string
+}
+
+// landingPageHandler returns a handler that serves a landing page listing the available endpoints
+// and the exporter version. The links are prefixed with basePath.
+func landingPageHandler(version string, basePath string, links []landingPageLink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingPageTemplate.Execute(w, struct {
+			Version  string
+			BasePath string
+			Links    []landingPageLink
+		}{version, basePath, links})
+		if err != nil {
+			log.Printf("Error rendering landing page: %v", err)
+		}
+	}
+}
+
+// healthzHandler reports that the exporter process is up.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("OK\n"))
+}
+
+// stakeAtRound represents the JSON response of the debug stake endpoint.
+type stakeAtRound struct {
+	Orchestrator string  `json:"orchestrator"`
+	Round        int     `json:"round"`
+	TotalStake   float64 `json:"total_stake"`
+}
+
+// debugStakeHandler returns a handler that reports the total stake of the orchestrator at the round
+// given by the 'round' query parameter, as recorded by the subgraph at the given endpoint.
+func debugStakeHandler(client *http.Client, subgraphEndpoint string, orchAddr string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		round, err := strconv.Atoi(r.URL.Query().Get("round"))
+		if err != nil || round < 0 {
+			http.Error(w, "query parameter 'round' should be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+
+		totalStake, err := util.GetStakeAtRound(client, subgraphEndpoint, orchAddr, round)
+		if errors.Is(err, util.ErrPoolNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving stake at round %d: %v", round, err)
+			http.Error(w, "failed to retrieve stake from the subgraph", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stakeAtRound{
+			Orchestrator: orchAddr,
+			Round:        round,
+			TotalStake:   totalStake,
+		})
+	}
+}
+
+// effectiveConfig represents the JSON response of the debug config endpoint.
+type effectiveConfig struct {
+	Config  map[string]string `json:"config"`
+	Unknown []string          `json:"unknown"`
+}
+
+// debugConfigHandler returns a handler that reports the value each environment variable took effect
+// with, including defaults, and the set 'LIVEPEER_EXPORTER_' variables that are not recognized. The
+// values of the redacted environment variables are hidden.
+func debugConfigHandler(redacted []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(effectiveConfig{
+			Config:  util.EffectiveEnv(redacted),
+			Unknown: util.UnknownEnv("LIVEPEER_EXPORTER_"),
+		})
+	}
+}
+
+// requireClientCertHandler wraps next so that requests without a verified TLS client certificate are
+// rejected, except for requests to one of the exemptPaths.
+func requireClientCertHandler(next http.Handler, exemptPaths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(exemptPaths, r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "a valid client certificate is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// basePathResponseWriter adds the base path to the absolute paths in the 'Location' header.
+type basePathResponseWriter struct {
+	http.ResponseWriter
+	basePath string
+}
+
+// WriteHeader adds the base path to the 'Location' header before writing the header.
+func (w *basePathResponseWriter) WriteHeader(code int) {
+	if location := w.Header().Get("Location"); strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		w.Header().Set("Location", w.basePath+location)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush flushes the underlying response writer, so that event streams keep working.
+func (w *basePathResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer for http.ResponseController.
+func (w *basePathResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// basePathHandler wraps next so that it can be served behind a reverse proxy under basePath (e.g.
+// '/livepeer'). The basePath is stripped from the request paths that start with it, so that both
+// proxies that strip it and proxies that pass it on are supported, and added to the absolute paths in
+// 'Location' headers.
+func basePathHandler(next http.Handler, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath || strings.HasPrefix(r.URL.Path, basePath+"/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = strings.TrimPrefix(r.URL.Path, basePath)
+			r2.URL.RawPath = ""
+			if r2.URL.Path == "" {
+				r2.URL.Path = "/"
+			}
+			r = r2
+		}
+		next.ServeHTTP(&basePathResponseWriter{ResponseWriter: w, basePath: basePath}, r)
+	})
+}
+
+// instrumentHandler wraps next so that its requests are counted per status code in the
+// 'livepeer_exporter_http_requests_total' metric, labelled with the given path.
+func instrumentHandler(path string, next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(metrics.HTTPRequestsTotal.MustCurryWith(prometheus.Labels{"path": path}), next)
+}
+
+// subExporter represents a sub-exporter that registers its metrics with its own registry.
+type subExporter interface {
+	runner.Exporter
+	Gatherer() prometheus.Gatherer
+	Fetch() bool
+}
+
+// metricFilter decides which metrics are exposed based on lists of metric name globs. A metric is
+// exposed when it matches the allow list, or the allow list is empty, and does not match the deny
+// list. When name is set, the orchestrator display name is added as label to the exposed metrics.
+type metricFilter struct {
+	allow []string
+	deny  []string
+	name  *displayName
+}
+
+// matches reports whether name matches any of the globs.
+func matches(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the metric with the given name should be exposed.
+func (f metricFilter) allowed(name string) bool {
+	if matches(f.deny, name) {
+		return false
+	}
+	return len(f.allow) == 0 || matches(f.allow, name)
+}
+
+// wrap returns a gatherer that only returns the metric families of gatherer that are allowed.
+func (f metricFilter) wrap(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	if f.name != nil {
+		gatherer = f.name.wrap(gatherer)
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return gatherer
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		return slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+			return !f.allowed(family.GetName())
+		}), err
+	})
+}
+
+// allGatherers returns the gatherers of the default registry and of all sub-exporters, filtered by
+// filter.
+func allGatherers(exporters []subExporter, filter metricFilter) prometheus.Gatherers {
+	all := prometheus.Gatherers{filter.wrap(prometheus.DefaultGatherer)}
+	for _, exporter := range exporters {
+		all = append(all, filter.wrap(exporter.Gatherer()))
+	}
+	return all
+}
+
+// metricsHandler returns a handler that serves the metrics of the default registry and of all
+// sub-exporters. The 'collect' query parameter can be set to a comma-separated list of sub-exporter
+// names to only serve the metrics of these sub-exporters (e.g. '?collect=orch_info,orch_tickets').
+// Metrics that are not allowed by filter are never served. When openMetrics is set, the OpenMetrics
+// format is served to scrapers that request it.
+func metricsHandler(exporters []subExporter, filter metricFilter, openMetrics bool) http.Handler {
+	gatherers := make(map[string]prometheus.Gatherer)
+	for _, exporter := range exporters {
+		gatherers[exporter.Name()] = filter.wrap(exporter.Gatherer())
+	}
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: openMetrics}
+	allHandler := promhttp.HandlerFor(allGatherers(exporters, filter), opts)
+
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collect := r.URL.Query().Get("collect")
+		if collect == "" {
+			allHandler.ServeHTTP(w, r)
+			return
+		}
+
+		var selected prometheus.Gatherers
+		for _, name := range strings.Split(collect, ",") {
+			gatherer, ok := gatherers[strings.TrimSpace(name)]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown exporter '%s' in query parameter 'collect'", name), http.StatusBadRequest)
+				return
+			}
+			selected = append(selected, gatherer)
+		}
+		promhttp.HandlerFor(selected, opts).ServeHTTP(w, r)
+	}))
+}
+
+// openMetricsContentType is the content type of the OpenMetrics exposition format.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// openMetricsHandler returns a handler that always requests the OpenMetrics format from next,
+// regardless of the 'Accept' header of the request, for clients that do not negotiate it properly.
+func openMetricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Header.Set("Accept", openMetricsContentType)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metricSample represents a sample of a metric in the events sent by the events endpoint.
+type metricSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// updateEvent represents the JSON data of an event sent by the events endpoint.
+type updateEvent struct {
+	Exporter string                    `json:"exporter"`
+	Time     time.Time                 `json:"time"`
+	Metrics  map[string][]metricSample `json:"metrics"`
+}
+
+// sampleValue returns the value of a gauge, counter or untyped metric. It reports false for the other
+// metric types.
+func sampleValue(metric *dto.Metric) (float64, bool) {
+	switch {
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue(), true
+	case metric.Counter != nil:
+		return metric.Counter.GetValue(), true
+	case metric.Untyped != nil:
+		return metric.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+// snapshotMetrics returns the gauge, counter and untyped metrics of gatherer by metric name.
+func snapshotMetrics(gatherer prometheus.Gatherer) (map[string][]metricSample, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string][]metricSample)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			value, ok := sampleValue(metric)
+			if !ok {
+				continue
+			}
+			var labels map[string]string
+			if len(metric.GetLabel()) > 0 {
+				labels = make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+			}
+			snapshot[family.GetName()] = append(snapshot[family.GetName()], metricSample{Labels: labels, Value: value})
+		}
+	}
+	return snapshot, nil
+}
+
+// authorized reports whether the request carries token as bearer token in the 'Authorization' header
+// or in the 'token' query parameter. The query parameter is accepted since browsers cannot set
+// headers on Server-Sent Events requests.
+func authorized(r *http.Request, token string) bool {
+	given := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// requireTokenHandler wraps next so that requests without the given token are rejected. The token is
+// accepted in the same ways as by the '/events' endpoint.
+func requireTokenHandler(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitConcurrencyHandler wraps next so that at most limit requests are handled at once. Requests
+// beyond the limit are rejected rather than queued, so that they cannot pile up upstream requests.
+func limitConcurrencyHandler(next http.Handler, limit int) http.Handler {
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+		}
+	})
+}
+
+// eventsHandler returns a handler that streams a JSON snapshot of the metrics of a sub-exporter as a
+// Server-Sent Event whenever it completes a metrics update. Requests without the given token are
+// rejected. Metrics that are not allowed by filter are never sent. The streams are closed when done
+// is closed, so that they do not hold up the shutdown of the server.
+func eventsHandler(exporters []subExporter, filter metricFilter, token string, done <-chan struct{}) http.HandlerFunc {
+	gatherers := make(map[string]prometheus.Gatherer)
+	for _, exporter := range exporters {
+		gatherers[exporter.Name()] = filter.wrap(exporter.Gatherer())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		updates := runner.Updates.Subscribe()
+		defer runner.Updates.Unsubscribe(updates)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			case update := <-updates:
+				gatherer, ok := gatherers[update.Exporter]
+				if !ok {
+					continue
+				}
+				snapshot, err := snapshotMetrics(gatherer)
+				if err != nil {
+					log.Printf("Error gathering %s metrics for the events endpoint: %v", update.Exporter, err)
+					continue
+				}
+				data, err := json.Marshal(updateEvent{Exporter: update.Exporter, Time: update.Time, Metrics: snapshot})
+				if err != nil {
+					log.Printf("Error encoding %s metrics for the events endpoint: %v", update.Exporter, err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: update\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
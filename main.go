@@ -4,16 +4,98 @@
 // The server provides a '8954/metrics' endpoint for Prometheus to scrape.
 //
 // The exporter has the following configuration environment variables:
-//   - LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS - The address of the orchestrator to fetch data from.
+//   - LIVEPEER_EXPORTER_ENV_FILE - The path of a file with 'KEY=VALUE' lines to load the other variables from. Variables that
+//     are already set in the environment take precedence.
+//   - LIVEPEER_EXPORTER_BIND_ADDRESS - The address the HTTP server binds to. Leave empty or use '::' to listen dual-stack on all
+//     interfaces, '0.0.0.0' to listen on all IPv4 interfaces only, or an explicit IPv4/IPv6 literal (e.g. '::1') to listen single-stack.
+//   - LIVEPEER_EXPORTER_PORT - The port the HTTP server listens on.
+//   - LIVEPEER_EXPORTER_HEALTH_PORT - A separate port to serve only the '/healthz' endpoint on.
+//   - LIVEPEER_EXPORTER_METRICS_ALLOW - A comma-separated list of metric name globs. Only matching metrics are exposed.
+//   - LIVEPEER_EXPORTER_METRICS_DENY - A comma-separated list of metric name globs. Matching metrics are never exposed.
+//   - LIVEPEER_EXPORTER_PUSHGATEWAY_URL - The URL of a Pushgateway to push the metrics to, for when Prometheus cannot scrape the exporter.
+//   - LIVEPEER_EXPORTER_PUSH_INTERVAL - How often to push the metrics to the Pushgateway.
+//   - LIVEPEER_EXPORTER_UNIX_SOCKET - The path of a Unix socket to serve on instead of the TCP address and port.
+//   - LIVEPEER_EXPORTER_METRICS_PATH - The path the metrics are served at.
+//   - LIVEPEER_EXPORTER_EXTERNAL_URL - The URL the exporter is reachable at through a reverse proxy. Its path is used as prefix
+//     for the landing page links and redirects.
+//   - LIVEPEER_EXPORTER_NETWORK - The Livepeer network to fetch data for ('arbitrum-mainnet' or 'arbitrum-testnet').
+//   - LIVEPEER_EXPORTER_SUBGRAPH_ENDPOINT - Overrides the Livepeer subgraph endpoint of the network.
+//   - LIVEPEER_EXPORTER_SCORE_ENDPOINT - Overrides the orchestrator score endpoint of the network. The '%s' is replaced by the orchestrator address.
+//   - LIVEPEER_EXPORTER_SCORE_ENDPOINT_BACKUP - A mirror of the orchestrator score endpoint that is used when the endpoint fails. The '%s' is replaced by the orchestrator address.
+//   - LIVEPEER_EXPORTER_CACHE_BUST - Whether to add a query parameter with the current time to explorer requests to bypass CDN caches.
+//   - LIVEPEER_EXPORTER_SCORE_LEADERBOARD_ENDPOINT - The endpoint to fetch the scores of all orchestrators from, used to compare the score with the network median.
+//   - LIVEPEER_EXPORTER_TEST_STREAMS_ENDPOINT - Overrides the test streams endpoint of the network. The '%s' is replaced by the orchestrator address.
+//   - LIVEPEER_EXPORTER_HISTORICAL_PRICES_ENDPOINT - The endpoint to fetch the ETH price in USD of a day from. The '%s' is replaced by the 'YYYY-MM-DD' date.
+//   - LIVEPEER_EXPORTER_TLS_CERT_FILE - The certificate file to serve HTTPS with. Requires 'LIVEPEER_EXPORTER_TLS_KEY_FILE'.
+//   - LIVEPEER_EXPORTER_TLS_KEY_FILE - The private key file of the certificate.
+//   - LIVEPEER_EXPORTER_TLS_MIN_VERSION - The minimum TLS version the HTTPS server accepts.
+//   - LIVEPEER_EXPORTER_TLS_CIPHER_SUITES - A comma-separated list of the TLS 1.0-1.2 cipher suites the HTTPS server accepts.
+//   - LIVEPEER_EXPORTER_TLS_CLIENT_CA_FILE - The CA certificate file to verify client certificates with. When set, scrapers have to
+//     present a client certificate signed by this CA.
+//   - LIVEPEER_EXPORTER_TLS_CLIENT_CERT_EXEMPT_HEALTHZ - Whether '/healthz' can be requested without a client certificate.
+//   - LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS - The address or ENS name (e.g. 'orchestrator.eth') of the orchestrator to fetch data from.
+//   - LIVEPEER_EXPORTER_ETHEREUM_RPC_URL - The Ethereum mainnet JSON-RPC endpoint used to resolve ENS names.
+//   - LIVEPEER_EXPORTER_ENS_RESOLVE_INTERVAL - How often to re-resolve the orchestrator ENS name.
+//   - LIVEPEER_EXPORTER_NAME_LABEL - Whether to add the orchestrator display name as 'name' label to all metrics.
+//   - LIVEPEER_EXPORTER_NAME_REFRESH_INTERVAL - How often to refresh the orchestrator display name.
+//   - LIVEPEER_EXPORTER_ARBITRUM_RPC_URL - The Arbitrum JSON-RPC endpoint used to fetch the ETH balance of the orchestrator.
+//   - LIVEPEER_EXPORTER_CRYPTO_PRICES_ENDPOINT - The endpoint to fetch the crypto prices from, in the format of the Coinbase exchange-rates API.
+//   - LIVEPEER_EXPORTER_CRYPTO_PRICES_API_KEY - The API key to send with the crypto prices requests.
+//   - LIVEPEER_EXPORTER_CRYPTO_PRICES_API_KEY_HEADER - The request header the crypto prices API key is sent in.
+//   - LIVEPEER_EXPORTER_CRYPTO_PRICES_CACHE_FILE - The file to cache the crypto prices in, so that they are not fetched again after a restart.
+//   - LIVEPEER_EXPORTER_CRYPTO_PRICES_CACHE_TTL - How long fetched crypto prices are used before they are fetched again.
+//   - LIVEPEER_EXPORTER_ETH_BALANCE_LOW_THRESHOLD - The ETH balance below which the 'livepeer_orch_eth_balance_low' metric is set.
+//   - LIVEPEER_EXPORTER_ORCH_NODE_URL - The URL of the CLI API of the orchestrator's go-livepeer node used to fetch its version and transcoder capacity.
 //   - LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY - The address of the secondary orchestrator to fetch data from. Used to
 //     calculate the 'livepeer_orch_stake' metric. When set the LPT stake of this address is added to the LPT stake that is bonded by the orchestrator.
+//   - LIVEPEER_EXPORTER_IGNORE_DUPLICATE_ADDRESSES - Whether to ignore a secondary address that equals the orchestrator address
+//     instead of exiting.
+//   - LIVEPEER_EXPORTER_ENABLE_DEBUG_ENDPOINTS - Whether to serve the '/debug/' endpoints. Requires LIVEPEER_EXPORTER_DEBUG_TOKEN.
+//   - LIVEPEER_EXPORTER_DEBUG_TOKEN - The token required by the '/debug/' and '/debug/pprof/' endpoints.
+//   - LIVEPEER_EXPORTER_ENABLE_PPROF - Whether to serve the Go profiling endpoints under '/debug/pprof/'. Requires LIVEPEER_EXPORTER_DEBUG_TOKEN.
+//   - LIVEPEER_EXPORTER_EVENTS_TOKEN - The token required by the '/events' endpoint. When set, the endpoint streams the metrics
+//     of each exporter as Server-Sent Events whenever it completes a metrics update.
+//   - LIVEPEER_EXPORTER_ENABLE_OPENMETRICS - Whether to serve the OpenMetrics format, including exemplars, to scrapers that request it and on '/openmetrics'.
+//   - LIVEPEER_EXPORTER_HTTP_TIMEOUT - The timeout for requests to the upstream APIs.
+//   - LIVEPEER_EXPORTER_DIAL_TIMEOUT - The timeout for connecting to the upstream APIs.
+//   - LIVEPEER_EXPORTER_TLS_HANDSHAKE_TIMEOUT - The timeout for the TLS handshake with the upstream APIs.
+//   - LIVEPEER_EXPORTER_RESPONSE_HEADER_TIMEOUT - The timeout for receiving the response headers from the upstream APIs.
+//   - LIVEPEER_EXPORTER_PROXY_URL - The proxy to send requests to the upstream APIs through. Overrides the 'HTTP_PROXY',
+//     'HTTPS_PROXY' and 'NO_PROXY' environment variables.
+//   - LIVEPEER_EXPORTER_UPSTREAM_CA_FILE - A CA certificate file to trust in addition to the system root CAs for the upstream APIs.
+//   - LIVEPEER_EXPORTER_UPSTREAM_INSECURE_SKIP_VERIFY - Whether to skip verifying the certificates of the upstream APIs.
+//   - LIVEPEER_EXPORTER_SLOW_WORKERS - The maximum number of concurrent requests to slow endpoints (e.g. the test streams API).
+//   - LIVEPEER_EXPORTER_MAX_RESPONSE_BYTES - The maximum size of a response body from the upstream APIs. Larger responses fail to fetch.
+//   - LIVEPEER_EXPORTER_BLOCK_TIME - The average Ethereum block time used to estimate the progress of the current round.
+//   - LIVEPEER_EXPORTER_SKIP_ON_INDEXING_ERRORS - Whether to discard subgraph responses that report indexing errors instead of
+//     updating the metrics with possibly stale data.
+//   - LIVEPEER_EXPORTER_FEES_PER_STAKE_WINDOW - The fee window ('30d', '90d' or 'total') used for the 'livepeer_orch_fees_per_stake' metric.
+//   - LIVEPEER_EXPORTER_CUT_HISTORY_ROUNDS - The number of past rounds covered by the reward and fee cut history metrics.
+//   - LIVEPEER_EXPORTER_TOP_DELEGATORS - The number of largest delegators whose per-delegator metrics are exposed. Zero exposes all delegators.
+//   - LIVEPEER_EXPORTER_WATCHED_DELEGATORS - A comma-separated list of delegator addresses whose per-delegator metrics are always exposed.
+//   - LIVEPEER_EXPORTER_REWARD_HISTORY_ROUNDS - The number of past rounds covered by the reward call history metric.
+//   - LIVEPEER_EXPORTER_TREND_WINDOW - The window trend metrics, such as the stake change rate, are calculated over.
+//   - LIVEPEER_EXPORTER_ACTIVE_SENDERS_WINDOW - The window in which a broadcaster must have sent a winning ticket to count as an active sender.
+//   - LIVEPEER_EXPORTER_TOKEN_DECIMALS - The number of decimal places LPT and ETH amounts are rounded to. A negative value keeps full precision.
+//   - LIVEPEER_EXPORTER_TEST_STREAMS_SUCCESS_THRESHOLD - The minimum success rate (0-1) for a test stream to count as passing.
+//   - LIVEPEER_EXPORTER_TEST_STREAMS_RECENT_RESULTS - The number of recent test streams per region covered by the recent test stream metrics.
+//   - LIVEPEER_EXPORTER_TEST_STREAMS_AGGREGATE - Whether to expose aggregates across regions instead of per-region test stream metrics.
+//   - LIVEPEER_EXPORTER_TEST_STREAMS_SUCCESS_RATE_WINDOW - The window the test stream success rate average is calculated over.
 //   - LIVEPEER_EXPORTER_INFO_FETCH_INTERVAL - How often to fetch general orchestrator information.
 //   - LIVEPEER_EXPORTER_SCORE_FETCH_INTERVAL - How often to fetch score data for the orchestrator.
 //   - LIVEPEER_EXPORTER_DELEGATORS_FETCH_INTERVAL - How often to fetch delegators data for the orchestrator.
 //   - LIVEPEER_EXPORTER_TEST_STREAMS_FETCH_INTERVAL - How often to fetch the test streams data for the orchestrator.
 //   - LIVEPEER_EXPORTER_TICKETS_FETCH_INTERVAL - How often to fetch tickets data for the orchestrator.
 //   - LIVEPEER_EXPORTER_REWARDS_FETCH_INTERVAL - How often to fetch rewards data for the orchestrator.
+//   - LIVEPEER_EXPORTER_REWARDS_CLAIMED_FETCH_INTERVAL - How often to fetch all reward events to calculate the claimed rewards total.
 //   - LIVEPEER_EXPORTER_CRYPTO_PRICES_FETCH_INTERVAL - How often to fetch crypto prices.
+//   - LIVEPEER_EXPORTER_ETH_BALANCE_FETCH_INTERVAL - How often to fetch the ETH balance of the orchestrator.
+//   - LIVEPEER_EXPORTER_ORCH_NODE_FETCH_INTERVAL - How often to fetch the status of the orchestrator node.
+//   - LIVEPEER_EXPORTER_STRICT_INTERVALS - Whether to exit instead of logging a warning when an update interval is longer than
+//     the corresponding fetch interval.
+//   - LIVEPEER_EXPORTER_STALE_THRESHOLD - The number of fetch intervals without a successful fetch after which the data of an
+//     exporter is reported as stale.
+//   - LIVEPEER_EXPORTER_STARTUP_SELF_TEST - Whether to fetch the data of each exporter once on startup and log a summary of the results.
 //   - LIVEPEER_EXPORTER_INFO_UPDATE_INTERVAL - How often to update the orchestrator info metrics.
 //   - LIVEPEER_EXPORTER_SCORE_UPDATE_INTERVAL - How often to update the orchestrator score metrics.
 //   - LIVEPEER_EXPORTER_DELEGATORS_UPDATE_INTERVAL - How often to update the orchestrator delegators metrics.
@@ -21,36 +103,189 @@
 //   - LIVEPEER_EXPORTER_TICKETS_UPDATE_INTERVAL - How often to update the orchestrator tickets metrics.
 //   - LIVEPEER_EXPORTER_REWARDS_UPDATE_INTERVAL - How often to update the orchestrator rewards metrics.
 //   - LIVEPEER_EXPORTER_CRYPTO_PRICES_UPDATE_INTERVAL - How often to update the crypto prices metrics.
+//   - LIVEPEER_EXPORTER_ETH_BALANCE_UPDATE_INTERVAL - How often to update the ETH balance metrics.
+//   - LIVEPEER_EXPORTER_ORCH_NODE_UPDATE_INTERVAL - How often to update the orchestrator node metrics.
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"livepeer-exporter/constants"
+	"livepeer-exporter/ens"
 	"livepeer-exporter/exporters/crypto_prices_exporter"
 	"livepeer-exporter/exporters/orch_delegators_exporter"
+	"livepeer-exporter/exporters/orch_eth_balance_exporter"
 	"livepeer-exporter/exporters/orch_info_exporter"
+	"livepeer-exporter/exporters/orch_node_exporter"
 	"livepeer-exporter/exporters/orch_rewards_exporter"
 	"livepeer-exporter/exporters/orch_score_exporter"
 	"livepeer-exporter/exporters/orch_test_streams_exporter"
 	"livepeer-exporter/exporters/orch_tickets_exporter"
+	"livepeer-exporter/fetcher"
+	"livepeer-exporter/metrics"
+	"livepeer-exporter/runner"
 	"livepeer-exporter/util"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 )
 
+// Build info. It is set at build time using
+// '-ldflags "-X main.version=<version> -X main.commit=<commit> -X main.buildDate=<date>"'.
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionString returns the exporter version together with the commit and date it was built from.
+func versionString() string {
+	return fmt.Sprintf("livepeer-exporter %s (commit: %s, build date: %s)", version, commit, buildDate)
+}
+
+// secondaryAddress returns the lowercased secondary address. When it equals the orchestrator address,
+// it is dropped if ignoreDuplicate is set and rejected otherwise, since the secondary stake is added to
+// the orchestrator stake and would be counted twice. Addresses are compared case-insensitively.
+func secondaryAddress(secondary string, orchAddr string, ignoreDuplicate bool) (string, error) {
+	secondary = strings.ToLower(secondary)
+	if secondary == "" || secondary != strings.ToLower(orchAddr) {
+		return secondary, nil
+	}
+	if !ignoreDuplicate {
+		return "", fmt.Errorf("'%v' should differ from LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS", secondary)
+	}
+	log.Printf("WARNING: Ignoring LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY '%v' since it equals LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS", secondary)
+	return "", nil
+}
+
+// reservedPaths contains the paths the exporter serves besides the metrics path. All paths under
+// '/debug/' are reserved as well. Paths that are only served when enabled are reserved regardless,
+// so that enabling them later does not break the metrics path.
+var reservedPaths = []string{openMetricsPath, "/healthz", "/events"}
+
+// validateMetricsPath returns an error when path can not be used as the metrics path because it is
+// not absolute or collides with one of the other paths the exporter serves.
+// NOTE: The root path is allowed, since the landing page is not served when metrics are served there.
+func validateMetricsPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("'%v' should start with a '/'", path)
+	}
+	if slices.Contains(reservedPaths, path) || path == "/debug" || strings.HasPrefix(path, "/debug/") {
+		return fmt.Errorf("'%v' collides with a path the exporter serves itself", path)
+	}
+	return nil
+}
+
+// redactedEnvVars contains the environment variables whose values are redacted on the '/debug/config'
+// endpoint, since they are API keys or their URLs may contain API keys or credentials.
+var redactedEnvVars = []string{
+	"LIVEPEER_EXPORTER_SUBGRAPH_ENDPOINT",
+	"LIVEPEER_EXPORTER_SCORE_ENDPOINT",
+	"LIVEPEER_EXPORTER_SCORE_ENDPOINT_BACKUP",
+	"LIVEPEER_EXPORTER_SCORE_LEADERBOARD_ENDPOINT",
+	"LIVEPEER_EXPORTER_TEST_STREAMS_ENDPOINT",
+	"LIVEPEER_EXPORTER_HISTORICAL_PRICES_ENDPOINT",
+	"LIVEPEER_EXPORTER_CRYPTO_PRICES_ENDPOINT",
+	"LIVEPEER_EXPORTER_CRYPTO_PRICES_API_KEY",
+	"LIVEPEER_EXPORTER_ARBITRUM_RPC_URL",
+	"LIVEPEER_EXPORTER_ETHEREUM_RPC_URL",
+	"LIVEPEER_EXPORTER_ORCH_NODE_URL",
+	"LIVEPEER_EXPORTER_EXTERNAL_URL",
+	"LIVEPEER_EXPORTER_PROXY_URL",
+	"LIVEPEER_EXPORTER_PUSHGATEWAY_URL",
+	"LIVEPEER_EXPORTER_EVENTS_TOKEN",
+	"LIVEPEER_EXPORTER_DEBUG_TOKEN",
+}
+
 // Exporter default config values.
 var (
+	// Address settings.
+	ignoreDuplicateAddressesDefault = false
+
+	// Endpoint settings.
+	cacheBustDefault = false
+
+	// Server settings.
+	bindAddressDefault  = ""
+	portDefault         = "9153"
+	metricsPathDefault  = "/metrics"
+	openMetricsPath     = "/openmetrics"
+	enableDebugDefault  = false
+	enablePprofDefault  = false
+	openMetricsDefault  = false
+	unixSocketDefault   = ""
+	pushIntervalDefault = 1 * time.Minute
+	shutdownTimeout     = 10 * time.Second
+
+	// TLS settings.
+	tlsMinVersionDefault              = "1.2"
+	tlsClientCertExemptHealthzDefault = false
+
+	// Client settings.
+	httpTimeoutDefault           = 1 * time.Minute
+	dialTimeoutDefault           = 30 * time.Second
+	tlsHandshakeTimeoutDefault   = 10 * time.Second
+	responseHeaderTimeoutDefault = 30 * time.Second
+	slowWorkersDefault           = 2
+	maxResponseBytesDefault      = 32 << 20
+
+	// Protocol settings.
+	blockTimeDefault            = 12 * time.Second
+	skipOnIndexingErrorsDefault = false
+	strictIntervalsDefault      = false
+	staleThresholdDefault       = 3.0
+	startupSelfTestDefault      = true
+	ensResolveIntervalDefault   = 1 * time.Hour
+	nameLabelDefault            = false
+	nameRefreshIntervalDefault  = 6 * time.Hour
+	feesPerStakeWindowDefault   = "30d"
+	tokenDecimalsDefault        = -1
+	cutHistoryRoundsDefault     = 30
+	rewardHistoryRoundsDefault  = 30
+	topDelegatorsDefault        = 0
+	activeSendersWindowDefault  = 24 * time.Hour
+	trendWindowDefault          = 1 * time.Hour
+
+	// Test streams settings.
+	testStreamsSuccessThresholdDefault  = 0.9
+	testStreamsRecentResultsDefault     = 5
+	testStreamsAggregateDefault         = false
+	testStreamsSuccessRateWindowDefault = 1 * time.Hour
+
+	// Crypto prices settings.
+	cryptoPricesAPIKeyHeaderDefault = "X-API-Key"
+	cryptoPricesCacheTTLDefault     = time.Duration(0)
+	cryptoPricesMinFetchInterval    = 30 * time.Second
+
+	// ETH balance settings.
+	ethBalanceLowThresholdDefault = 0.01
+
 	// Fetch intervals.
-	infoFetchIntervalDefault        = 2 * time.Minute
-	scoreFetchIntervalDefault       = 15 * time.Minute
-	delegatorsFetchIntervalDefault  = 15 * time.Minute
-	testStreamsFetchIntervalDefault = 15 * time.Minute
-	ticketsFetchIntervalDefault     = 15 * time.Minute
-	rewardsFetchIntervalDefault     = 15 * time.Minute
-	cryptoPricesFetchInterval       = 1 * time.Minute
+	infoFetchIntervalDefault           = 2 * time.Minute
+	scoreFetchIntervalDefault          = 15 * time.Minute
+	delegatorsFetchIntervalDefault     = 15 * time.Minute
+	testStreamsFetchIntervalDefault    = 15 * time.Minute
+	ticketsFetchIntervalDefault        = 15 * time.Minute
+	rewardsFetchIntervalDefault        = 15 * time.Minute
+	rewardsClaimedFetchIntervalDefault = 6 * time.Hour
+	cryptoPricesFetchInterval          = 1 * time.Minute
+	ethBalanceFetchIntervalDefault     = 5 * time.Minute
+	orchNodeFetchIntervalDefault       = 5 * time.Minute
 
 	// Update intervals.
 	infoUpdateIntervalDefault         = 1 * time.Minute
@@ -60,18 +295,105 @@ var (
 	ticketsUpdateIntervalDefault      = 1 * time.Minute
 	rewardsUpdateIntervalDefault      = 1 * time.Minute
 	cryptoPricesUpdateIntervalDefault = 1 * time.Minute
+	ethBalanceUpdateIntervalDefault   = 1 * time.Minute
+	orchNodeUpdateIntervalDefault     = 1 * time.Minute
 )
 
 // Default config values.
 func main() {
-	log.Println("Starting Livepeer exporter...")
+	// Print the version and exit when requested.
+	if len(os.Args) > 1 && slices.Contains([]string{"--version", "-version", "version"}, os.Args[1]) {
+		fmt.Println(versionString())
+		return
+	}
+
+	log.Printf("Starting %s...", versionString())
+
+	// Load environment variables from the env file if one is given.
+	if envFile := util.GetEnvString("LIVEPEER_EXPORTER_ENV_FILE", ""); envFile != "" {
+		if err := util.LoadEnvFile(envFile); err != nil {
+			log.Fatalf("Error loading LIVEPEER_EXPORTER_ENV_FILE '%v': %v", envFile, err)
+		}
+	}
+
+	// Create the HTTP clients. The fast exporters share one client, while the exporters of slow
+	// endpoints share a separate client with a bounded number of concurrent requests.
+	var proxyURL *url.URL
+	if proxy := util.GetEnvString("LIVEPEER_EXPORTER_PROXY_URL", ""); proxy != "" {
+		var err error
+		if proxyURL, err = url.Parse(proxy); err != nil {
+			log.Fatalf("Error parsing LIVEPEER_EXPORTER_PROXY_URL '%v': %v", proxy, err)
+		}
+	}
+	clientConfig := fetcher.ClientConfig{
+		Timeout:               util.GetEnvDuration("LIVEPEER_EXPORTER_HTTP_TIMEOUT", httpTimeoutDefault),
+		DialTimeout:           util.GetEnvDuration("LIVEPEER_EXPORTER_DIAL_TIMEOUT", dialTimeoutDefault),
+		TLSHandshakeTimeout:   util.GetEnvDuration("LIVEPEER_EXPORTER_TLS_HANDSHAKE_TIMEOUT", tlsHandshakeTimeoutDefault),
+		ResponseHeaderTimeout: util.GetEnvDuration("LIVEPEER_EXPORTER_RESPONSE_HEADER_TIMEOUT", responseHeaderTimeoutDefault),
+		ProxyURL:              proxyURL,
+		InsecureSkipVerify:    util.GetEnvBool("LIVEPEER_EXPORTER_UPSTREAM_INSECURE_SKIP_VERIFY", false),
+		MaxResponseBytes:      int64(util.GetEnvInt("LIVEPEER_EXPORTER_MAX_RESPONSE_BYTES", maxResponseBytesDefault)),
+	}
+	if clientConfig.MaxResponseBytes < 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_MAX_RESPONSE_BYTES '%v' should not be negative", clientConfig.MaxResponseBytes)
+	}
+	if caFile := util.GetEnvString("LIVEPEER_EXPORTER_UPSTREAM_CA_FILE", ""); caFile != "" {
+		var err error
+		if clientConfig.RootCAs, err = util.LoadRootCAs(caFile); err != nil {
+			log.Fatalf("Error loading LIVEPEER_EXPORTER_UPSTREAM_CA_FILE: %v", err)
+		}
+	}
+	if clientConfig.InsecureSkipVerify {
+		log.Printf("WARNING: LIVEPEER_EXPORTER_UPSTREAM_INSECURE_SKIP_VERIFY is enabled. Upstream certificates are not verified, which should only be done during development")
+	}
+	client := fetcher.NewHTTPClient(clientConfig)
+	slowClientConfig := clientConfig
+	slowClientConfig.MaxConcurrentRequests = util.GetEnvInt("LIVEPEER_EXPORTER_SLOW_WORKERS", slowWorkersDefault)
+	if slowClientConfig.MaxConcurrentRequests < 1 {
+		log.Fatalf("LIVEPEER_EXPORTER_SLOW_WORKERS '%v' should be at least 1", slowClientConfig.MaxConcurrentRequests)
+	}
+	slowClient := fetcher.NewHTTPClient(slowClientConfig)
+
+	// Retrieve the network and its upstream endpoints.
+	networkName := util.GetEnvString("LIVEPEER_EXPORTER_NETWORK", constants.DefaultNetwork)
+	network, ok := constants.Networks[networkName]
+	if !ok {
+		log.Fatalf("LIVEPEER_EXPORTER_NETWORK '%v' is not a supported network", networkName)
+	}
+	subgraphEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_SUBGRAPH_ENDPOINT", network.SubgraphEndpoint)
+	scoreEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_SCORE_ENDPOINT", network.ScoreEndpointTemplate)
+	scoreEndpointBackup := util.GetEnvString("LIVEPEER_EXPORTER_SCORE_ENDPOINT_BACKUP", "")
+	scoreLeaderboardEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_SCORE_LEADERBOARD_ENDPOINT", "")
+	cacheBust := util.GetEnvBool("LIVEPEER_EXPORTER_CACHE_BUST", cacheBustDefault)
+	historicalPricesEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_HISTORICAL_PRICES_ENDPOINT", "")
+	testStreamsEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_TEST_STREAMS_ENDPOINT", network.TestStreamsEndpointTemplate)
+	if subgraphEndpoint == "" {
+		log.Fatalf("'LIVEPEER_EXPORTER_SUBGRAPH_ENDPOINT' environment variable should be set for network '%v'", networkName)
+	}
 
 	// Retrieve orchestrator address and validate it.
-	orchAddr := strings.ToLower(os.Getenv("LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS"))
+	orchAddr := strings.ToLower(util.GetEnvString("LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS", ""))
 	if orchAddr == "" {
 		log.Fatal("'LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS' environment variable should be set")
 	}
-	isOrch, err := util.IsOrchestrator(orchAddr)
+
+	// Resolve the orchestrator address if an ENS name was given.
+	// NOTE: ENS names are registered on Ethereum mainnet, so they are resolved through an Ethereum RPC.
+	ethereumRPCURL := util.GetEnvString("LIVEPEER_EXPORTER_ETHEREUM_RPC_URL", "")
+	ensName := ""
+	if ens.IsName(orchAddr) {
+		if ethereumRPCURL == "" {
+			log.Fatalf("'LIVEPEER_EXPORTER_ETHEREUM_RPC_URL' environment variable should be set to resolve ENS name '%v'", orchAddr)
+		}
+		ensName = orchAddr
+		resolved, err := ens.Resolve(client, ethereumRPCURL, ensName)
+		if err != nil {
+			log.Fatalf("Error resolving ENS name '%v': %v", ensName, err)
+		}
+		orchAddr = resolved
+		log.Printf("Resolved ENS name '%v' to orchestrator address '%v'", ensName, orchAddr)
+	}
+	isOrch, err := util.IsOrchestrator(client, subgraphEndpoint, orchAddr)
 	if err != nil {
 		log.Fatalf("Error checking if address %v is an orchestrator: %v", orchAddr, err)
 	}
@@ -80,8 +402,14 @@ func main() {
 	}
 
 	// Retrieve secondary orchestrator address and validate it.
-	orchAddrSecondary := strings.ToLower(os.Getenv("LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY"))
-	isDelegator, err := util.IsDelegator(orchAddrSecondary)
+	orchAddrSecondary, err := secondaryAddress(
+		util.GetEnvString("LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY", ""), orchAddr,
+		util.GetEnvBool("LIVEPEER_EXPORTER_IGNORE_DUPLICATE_ADDRESSES", ignoreDuplicateAddressesDefault),
+	)
+	if err != nil {
+		log.Fatalf("Invalid LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY: %v", err)
+	}
+	isDelegator, err := util.IsDelegator(client, subgraphEndpoint, orchAddrSecondary)
 	if err != nil {
 		log.Fatalf("Error checking if address %v is a delegator: %v", orchAddrSecondary, err)
 	}
@@ -89,49 +417,415 @@ func main() {
 		log.Fatalf("LIVEPEER_EXPORTER_ORCHESTRATOR_ADDRESS_SECONDARY '%v' is not a valid Livepeer delegator", orchAddrSecondary)
 	}
 
+	// Retrieve the watched delegator addresses and validate them.
+	watchedDelegators := util.GetEnvList("LIVEPEER_EXPORTER_WATCHED_DELEGATORS")
+	for i, address := range watchedDelegators {
+		if !util.IsAddress(address) {
+			log.Fatalf("LIVEPEER_EXPORTER_WATCHED_DELEGATORS address '%v' is not a valid address", address)
+		}
+		watchedDelegators[i] = strings.ToLower(address)
+	}
+	topDelegators := util.GetEnvInt("LIVEPEER_EXPORTER_TOP_DELEGATORS", topDelegatorsDefault)
+	if topDelegators < 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_TOP_DELEGATORS '%v' should not be negative", topDelegators)
+	}
+
+	// Retrieve server settings.
+	bindAddress := util.GetEnvString("LIVEPEER_EXPORTER_BIND_ADDRESS", bindAddressDefault)
+	port := util.GetEnvString("LIVEPEER_EXPORTER_PORT", portDefault)
+	healthPort := util.GetEnvString("LIVEPEER_EXPORTER_HEALTH_PORT", "")
+	metricsPath := util.GetEnvString("LIVEPEER_EXPORTER_METRICS_PATH", metricsPathDefault)
+	if err := validateMetricsPath(metricsPath); err != nil {
+		log.Fatalf("Invalid LIVEPEER_EXPORTER_METRICS_PATH: %v", err)
+	}
+	var basePath string
+	if externalURL := util.GetEnvString("LIVEPEER_EXPORTER_EXTERNAL_URL", ""); externalURL != "" {
+		parsedURL, err := url.Parse(externalURL)
+		if err != nil {
+			log.Fatalf("Error parsing LIVEPEER_EXPORTER_EXTERNAL_URL '%v': %v", externalURL, err)
+		}
+		basePath = strings.TrimSuffix(parsedURL.Path, "/")
+	}
+	enableDebug := util.GetEnvBool("LIVEPEER_EXPORTER_ENABLE_DEBUG_ENDPOINTS", enableDebugDefault)
+	enablePprof := util.GetEnvBool("LIVEPEER_EXPORTER_ENABLE_PPROF", enablePprofDefault)
+	debugToken := util.GetEnvString("LIVEPEER_EXPORTER_DEBUG_TOKEN", "")
+	if enableDebug && debugToken == "" {
+		log.Fatal("LIVEPEER_EXPORTER_DEBUG_TOKEN is required when LIVEPEER_EXPORTER_ENABLE_DEBUG_ENDPOINTS is enabled")
+	}
+	if enablePprof && debugToken == "" {
+		log.Fatal("LIVEPEER_EXPORTER_DEBUG_TOKEN is required when LIVEPEER_EXPORTER_ENABLE_PPROF is enabled")
+	}
+	eventsToken := util.GetEnvString("LIVEPEER_EXPORTER_EVENTS_TOKEN", "")
+	util.ExemplarsEnabled = util.GetEnvBool("LIVEPEER_EXPORTER_ENABLE_OPENMETRICS", openMetricsDefault)
+	unixSocket := util.GetEnvString("LIVEPEER_EXPORTER_UNIX_SOCKET", unixSocketDefault)
+	pushgatewayURL := util.GetEnvString("LIVEPEER_EXPORTER_PUSHGATEWAY_URL", "")
+	pushInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_PUSH_INTERVAL", pushIntervalDefault)
+	filter := metricFilter{
+		allow: util.GetEnvList("LIVEPEER_EXPORTER_METRICS_ALLOW"),
+		deny:  util.GetEnvList("LIVEPEER_EXPORTER_METRICS_DENY"),
+	}
+	if util.GetEnvBool("LIVEPEER_EXPORTER_NAME_LABEL", nameLabelDefault) {
+		filter.name = newDisplayName(orchAddr)
+	}
+	for _, glob := range append(slices.Clone(filter.allow), filter.deny...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			log.Fatalf("Invalid metric name glob '%v': %v", glob, err)
+		}
+	}
+
+	// Retrieve TLS settings.
+	tlsCertFile := util.GetEnvString("LIVEPEER_EXPORTER_TLS_CERT_FILE", "")
+	tlsKeyFile := util.GetEnvString("LIVEPEER_EXPORTER_TLS_KEY_FILE", "")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("LIVEPEER_EXPORTER_TLS_CERT_FILE and LIVEPEER_EXPORTER_TLS_KEY_FILE should be set together")
+	}
+	tlsMinVersion, err := util.ParseTLSVersion(util.GetEnvString("LIVEPEER_EXPORTER_TLS_MIN_VERSION", tlsMinVersionDefault))
+	if err != nil {
+		log.Fatalf("Error parsing LIVEPEER_EXPORTER_TLS_MIN_VERSION: %v", err)
+	}
+	tlsCipherSuites, err := util.ParseCipherSuites(util.GetEnvString("LIVEPEER_EXPORTER_TLS_CIPHER_SUITES", ""))
+	if err != nil {
+		log.Fatalf("Error parsing LIVEPEER_EXPORTER_TLS_CIPHER_SUITES: %v", err)
+	}
+	tlsClientCAFile := util.GetEnvString("LIVEPEER_EXPORTER_TLS_CLIENT_CA_FILE", "")
+	if tlsClientCAFile != "" && tlsCertFile == "" {
+		log.Fatal("LIVEPEER_EXPORTER_TLS_CLIENT_CA_FILE requires LIVEPEER_EXPORTER_TLS_CERT_FILE and LIVEPEER_EXPORTER_TLS_KEY_FILE to be set")
+	}
+	tlsClientCertExemptHealthz := util.GetEnvBool("LIVEPEER_EXPORTER_TLS_CLIENT_CERT_EXEMPT_HEALTHZ", tlsClientCertExemptHealthzDefault)
+
+	// Retrieve protocol settings.
+	blockTime := util.GetEnvDuration("LIVEPEER_EXPORTER_BLOCK_TIME", blockTimeDefault)
+	if blockTime <= 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_BLOCK_TIME '%v' should be positive", blockTime)
+	}
+	fetcher.SkipOnIndexingErrors = util.GetEnvBool("LIVEPEER_EXPORTER_SKIP_ON_INDEXING_ERRORS", skipOnIndexingErrorsDefault)
+	feesPerStakeWindow := util.GetEnvString("LIVEPEER_EXPORTER_FEES_PER_STAKE_WINDOW", feesPerStakeWindowDefault)
+	if !slices.Contains(orch_info_exporter.FeesPerStakeWindows, feesPerStakeWindow) {
+		log.Fatalf("LIVEPEER_EXPORTER_FEES_PER_STAKE_WINDOW '%v' should be one of %v", feesPerStakeWindow, orch_info_exporter.FeesPerStakeWindows)
+	}
+	util.TokenDecimals = util.GetEnvInt("LIVEPEER_EXPORTER_TOKEN_DECIMALS", tokenDecimalsDefault)
+	if util.TokenDecimals > util.MaxTokenDecimals {
+		log.Fatalf("LIVEPEER_EXPORTER_TOKEN_DECIMALS '%v' should be at most %v", util.TokenDecimals, util.MaxTokenDecimals)
+	}
+	cutHistoryRounds := util.GetEnvInt("LIVEPEER_EXPORTER_CUT_HISTORY_ROUNDS", cutHistoryRoundsDefault)
+	if cutHistoryRounds < 0 || cutHistoryRounds > orch_info_exporter.MaxCutHistoryRounds {
+		log.Fatalf("LIVEPEER_EXPORTER_CUT_HISTORY_ROUNDS '%v' should be between 0 and %v", cutHistoryRounds, orch_info_exporter.MaxCutHistoryRounds)
+	}
+	rewardHistoryRounds := util.GetEnvInt("LIVEPEER_EXPORTER_REWARD_HISTORY_ROUNDS", rewardHistoryRoundsDefault)
+	if rewardHistoryRounds < 0 || rewardHistoryRounds > orch_rewards_exporter.MaxRewardHistoryRounds {
+		log.Fatalf("LIVEPEER_EXPORTER_REWARD_HISTORY_ROUNDS '%v' should be between 0 and %v", rewardHistoryRounds, orch_rewards_exporter.MaxRewardHistoryRounds)
+	}
+	trendWindow := util.GetEnvDuration("LIVEPEER_EXPORTER_TREND_WINDOW", trendWindowDefault)
+	if trendWindow <= 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_TREND_WINDOW '%v' should be positive", trendWindow)
+	}
+	activeSendersWindow := util.GetEnvDuration("LIVEPEER_EXPORTER_ACTIVE_SENDERS_WINDOW", activeSendersWindowDefault)
+	if activeSendersWindow <= 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_ACTIVE_SENDERS_WINDOW '%v' should be positive", activeSendersWindow)
+	}
+
+	// Retrieve test streams settings.
+	testStreamsSuccessThreshold := util.GetEnvFloat("LIVEPEER_EXPORTER_TEST_STREAMS_SUCCESS_THRESHOLD", testStreamsSuccessThresholdDefault)
+	if testStreamsSuccessThreshold < 0 || testStreamsSuccessThreshold > 1 {
+		log.Fatalf("LIVEPEER_EXPORTER_TEST_STREAMS_SUCCESS_THRESHOLD '%v' should be between 0 and 1", testStreamsSuccessThreshold)
+	}
+	testStreamsRecentResults := util.GetEnvInt("LIVEPEER_EXPORTER_TEST_STREAMS_RECENT_RESULTS", testStreamsRecentResultsDefault)
+	if testStreamsRecentResults < 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_TEST_STREAMS_RECENT_RESULTS '%v' should not be negative", testStreamsRecentResults)
+	}
+	testStreamsAggregate := util.GetEnvBool("LIVEPEER_EXPORTER_TEST_STREAMS_AGGREGATE", testStreamsAggregateDefault)
+	testStreamsSuccessRateWindow := util.GetEnvInterval("LIVEPEER_EXPORTER_TEST_STREAMS_SUCCESS_RATE_WINDOW", testStreamsSuccessRateWindowDefault)
+
+	// Retrieve crypto prices settings.
+	cryptoPricesEndpoint := util.GetEnvString("LIVEPEER_EXPORTER_CRYPTO_PRICES_ENDPOINT", crypto_prices_exporter.DefaultEndpoint)
+	cryptoPricesAPIKey := util.GetEnvString("LIVEPEER_EXPORTER_CRYPTO_PRICES_API_KEY", "")
+	cryptoPricesAPIKeyHeader := util.GetEnvString("LIVEPEER_EXPORTER_CRYPTO_PRICES_API_KEY_HEADER", cryptoPricesAPIKeyHeaderDefault)
+	cryptoPricesCacheFile := util.GetEnvString("LIVEPEER_EXPORTER_CRYPTO_PRICES_CACHE_FILE", "")
+	cryptoPricesCacheTTL := util.GetEnvDuration("LIVEPEER_EXPORTER_CRYPTO_PRICES_CACHE_TTL", cryptoPricesCacheTTLDefault)
+	if cryptoPricesCacheTTL < 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_CRYPTO_PRICES_CACHE_TTL '%v' should not be negative", cryptoPricesCacheTTL)
+	}
+
+	// Retrieve ETH balance settings.
+	arbitrumRPCURL := util.GetEnvString("LIVEPEER_EXPORTER_ARBITRUM_RPC_URL", "")
+	orchNodeURL := util.GetEnvString("LIVEPEER_EXPORTER_ORCH_NODE_URL", "")
+	ethBalanceLowThreshold := util.GetEnvFloat("LIVEPEER_EXPORTER_ETH_BALANCE_LOW_THRESHOLD", ethBalanceLowThresholdDefault)
+	if ethBalanceLowThreshold < 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_ETH_BALANCE_LOW_THRESHOLD '%v' should not be negative", ethBalanceLowThreshold)
+	}
+
 	// Retrieve fetch intervals.
-	infoFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_INFO_FETCH_INTERVAL", infoFetchIntervalDefault)
-	scoreFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_SCORE_FETCH_INTERVAL", scoreFetchIntervalDefault)
-	delegatorsFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_DELEGATORS_FETCH_INTERVAL", delegatorsFetchIntervalDefault)
-	testStreamFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_TEST_STREAMS_FETCH_INTERVAL", testStreamsFetchIntervalDefault)
-	ticketsFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_TICKETS_FETCH_INTERVAL", ticketsFetchIntervalDefault)
-	rewardsFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_REWARDS_FETCH_INTERVAL", rewardsFetchIntervalDefault)
-	cryptoPricesFetchInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_CRYPTO_PRICES_FETCH_INTERVAL", cryptoPricesFetchInterval)
+	infoFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_INFO_FETCH_INTERVAL", infoFetchIntervalDefault)
+	scoreFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_SCORE_FETCH_INTERVAL", scoreFetchIntervalDefault)
+	delegatorsFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_DELEGATORS_FETCH_INTERVAL", delegatorsFetchIntervalDefault)
+	testStreamFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_TEST_STREAMS_FETCH_INTERVAL", testStreamsFetchIntervalDefault)
+	ticketsFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_TICKETS_FETCH_INTERVAL", ticketsFetchIntervalDefault)
+	rewardsFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_REWARDS_FETCH_INTERVAL", rewardsFetchIntervalDefault)
+	rewardsClaimedFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_REWARDS_CLAIMED_FETCH_INTERVAL", rewardsClaimedFetchIntervalDefault)
+	cryptoPricesFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_CRYPTO_PRICES_FETCH_INTERVAL", cryptoPricesFetchInterval)
+	if cryptoPricesFetchInterval < cryptoPricesMinFetchInterval {
+		// NOTE: Free price APIs rate-limit aggressively, so shorter intervals quickly use up the quota.
+		log.Printf("WARNING: LIVEPEER_EXPORTER_CRYPTO_PRICES_FETCH_INTERVAL '%v' is shorter than the minimum of %v, using %v instead", cryptoPricesFetchInterval, cryptoPricesMinFetchInterval, cryptoPricesMinFetchInterval)
+		cryptoPricesFetchInterval = cryptoPricesMinFetchInterval
+	}
+	ethBalanceFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_ETH_BALANCE_FETCH_INTERVAL", ethBalanceFetchIntervalDefault)
+	orchNodeFetchInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_ORCH_NODE_FETCH_INTERVAL", orchNodeFetchIntervalDefault)
 
 	// Retrieve update intervals.
-	infoUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_INFO_UPDATE_INTERVAL", infoUpdateIntervalDefault)
-	scoreUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_SCORE_UPDATE_INTERVAL", scoreUpdateIntervalDefault)
-	delegatorsUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_DELEGATORS_UPDATE_INTERVAL", delegatorsUpdateIntervalDefault)
-	testStreamUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_TEST_STREAMS_UPDATE_INTERVAL", testStreamsUpdateIntervalDefault)
-	ticketsUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_TICKETS_UPDATE_INTERVAL", ticketsUpdateIntervalDefault)
-	rewardsUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_REWARDS_UPDATE_INTERVAL", rewardsUpdateIntervalDefault)
-	cryptoPricesUpdateInterval := util.GetEnvDuration("LIVEPEER_EXPORTER_CRYPTO_PRICES_UPDATE_INTERVAL", cryptoPricesUpdateIntervalDefault)
+	infoUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_INFO_UPDATE_INTERVAL", infoUpdateIntervalDefault)
+	scoreUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_SCORE_UPDATE_INTERVAL", scoreUpdateIntervalDefault)
+	delegatorsUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_DELEGATORS_UPDATE_INTERVAL", delegatorsUpdateIntervalDefault)
+	testStreamUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_TEST_STREAMS_UPDATE_INTERVAL", testStreamsUpdateIntervalDefault)
+	ticketsUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_TICKETS_UPDATE_INTERVAL", ticketsUpdateIntervalDefault)
+	rewardsUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_REWARDS_UPDATE_INTERVAL", rewardsUpdateIntervalDefault)
+	cryptoPricesUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_CRYPTO_PRICES_UPDATE_INTERVAL", cryptoPricesUpdateIntervalDefault)
+	ethBalanceUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_ETH_BALANCE_UPDATE_INTERVAL", ethBalanceUpdateIntervalDefault)
+	orchNodeUpdateInterval := util.GetEnvInterval("LIVEPEER_EXPORTER_ORCH_NODE_UPDATE_INTERVAL", orchNodeUpdateIntervalDefault)
+
+	runner.StaleAfterIntervals = util.GetEnvFloat("LIVEPEER_EXPORTER_STALE_THRESHOLD", staleThresholdDefault)
+	if runner.StaleAfterIntervals <= 0 {
+		log.Fatalf("LIVEPEER_EXPORTER_STALE_THRESHOLD '%v' should be positive", runner.StaleAfterIntervals)
+	}
+	startupSelfTest := util.GetEnvBool("LIVEPEER_EXPORTER_STARTUP_SELF_TEST", startupSelfTestDefault)
+
+	// Check that the metrics are updated at least as often as the data is fetched.
+	// NOTE: Otherwise fetched data is overwritten before it is exposed and the metrics lag behind.
+	strictIntervals := util.GetEnvBool("LIVEPEER_EXPORTER_STRICT_INTERVALS", strictIntervalsDefault)
+	for _, intervals := range []struct {
+		name           string
+		fetchInterval  time.Duration
+		updateInterval time.Duration
+	}{
+		{"INFO", infoFetchInterval, infoUpdateInterval},
+		{"SCORE", scoreFetchInterval, scoreUpdateInterval},
+		{"DELEGATORS", delegatorsFetchInterval, delegatorsUpdateInterval},
+		{"TEST_STREAMS", testStreamFetchInterval, testStreamUpdateInterval},
+		{"TICKETS", ticketsFetchInterval, ticketsUpdateInterval},
+		{"REWARDS", rewardsFetchInterval, rewardsUpdateInterval},
+		{"CRYPTO_PRICES", cryptoPricesFetchInterval, cryptoPricesUpdateInterval},
+		{"ETH_BALANCE", ethBalanceFetchInterval, ethBalanceUpdateInterval},
+		{"ORCH_NODE", orchNodeFetchInterval, orchNodeUpdateInterval},
+	} {
+		if intervals.updateInterval <= intervals.fetchInterval {
+			continue
+		}
+		msg := fmt.Sprintf("LIVEPEER_EXPORTER_%[1]s_UPDATE_INTERVAL '%[2]v' is longer than LIVEPEER_EXPORTER_%[1]s_FETCH_INTERVAL '%[3]v', so fetched data is only exposed every %[2]v", intervals.name, intervals.updateInterval, intervals.fetchInterval)
+		if strictIntervals {
+			log.Fatal(msg)
+		}
+		log.Printf("WARNING: %s", msg)
+	}
 
 	// Setup sub-exporters.
+	// NOTE: The score and test streams exporters are skipped on networks that do not provide these APIs
+	// and the ETH balance exporter is skipped when no Arbitrum RPC is configured.
+	// NOTE: Without historical prices, the tickets exporter values fees at the ETH price fetched by the
+	// crypto prices exporter, so that it does not need to fetch the price itself.
 	log.Println("Setting up sub exporters...")
-	orchInfoExporter := orch_info_exporter.NewOrchInfoExporter(orchAddr, infoFetchInterval, infoUpdateInterval, orchAddrSecondary)
-	orchScoreExporter := orch_score_exporter.NewOrchScoreExporter(orchAddr, scoreFetchInterval, scoreUpdateInterval)
-	orchDelegatorsExporter := orch_delegators_exporter.NewOrchDelegatorsExporter(orchAddr, delegatorsFetchInterval, delegatorsUpdateInterval)
-	orchTestStreamsExporter := orch_test_streams_exporter.NewOrchTestStreamsExporter(orchAddr, testStreamFetchInterval, testStreamUpdateInterval)
-	orchTicketsExporter := orch_tickets_exporter.NewOrchTicketsExporter(orchAddr, ticketsFetchInterval, ticketsUpdateInterval)
-	orchRewardsExporter := orch_rewards_exporter.NewOrchRewardsExporter(orchAddr, rewardsFetchInterval, rewardsUpdateInterval)
-	cryptoPricesExporter := crypto_prices_exporter.NewCryptoPricesExporter(cryptoPricesFetchInterval, cryptoPricesUpdateInterval)
+	cryptoPricesExporter := crypto_prices_exporter.NewCryptoPricesExporter(cryptoPricesFetchInterval, cryptoPricesUpdateInterval, cryptoPricesCacheFile, cryptoPricesCacheTTL, cryptoPricesAPIKey, cryptoPricesAPIKeyHeader, cryptoPricesEndpoint, client)
+	exporters := []subExporter{
+		orch_info_exporter.NewOrchInfoExporter(orchAddr, infoFetchInterval, infoUpdateInterval, orchAddrSecondary, blockTime, feesPerStakeWindow, cutHistoryRounds, trendWindow, subgraphEndpoint, client),
+		orch_delegators_exporter.NewOrchDelegatorsExporter(orchAddr, delegatorsFetchInterval, delegatorsUpdateInterval, topDelegators, watchedDelegators, subgraphEndpoint, client),
+		orch_tickets_exporter.NewOrchTicketsExporter(orchAddr, ticketsFetchInterval, ticketsUpdateInterval, historicalPricesEndpoint, cryptoPricesExporter.ETHUSDPrice, activeSendersWindow, subgraphEndpoint, client),
+		orch_rewards_exporter.NewOrchRewardsExporter(orchAddr, rewardsFetchInterval, rewardsUpdateInterval, rewardsClaimedFetchInterval, rewardHistoryRounds, subgraphEndpoint, client),
+		cryptoPricesExporter,
+	}
+	if scoreEndpoint != "" {
+		exporters = append(exporters, orch_score_exporter.NewOrchScoreExporter(orchAddr, scoreFetchInterval, scoreUpdateInterval, scoreEndpoint, scoreEndpointBackup, scoreLeaderboardEndpoint, cacheBust, client))
+	} else {
+		log.Printf("Skipping orchestrator score exporter since network '%v' has no score endpoint", networkName)
+	}
+	if testStreamsEndpoint != "" {
+		exporters = append(exporters, orch_test_streams_exporter.NewOrchTestStreamsExporter(orchAddr, testStreamFetchInterval, testStreamUpdateInterval, testStreamsSuccessThreshold, testStreamsRecentResults, testStreamsAggregate, testStreamsSuccessRateWindow, testStreamsEndpoint, slowClient))
+	} else {
+		log.Printf("Skipping orchestrator test streams exporter since network '%v' has no test streams endpoint", networkName)
+	}
+	if arbitrumRPCURL != "" {
+		exporters = append(exporters, orch_eth_balance_exporter.NewOrchETHBalanceExporter(orchAddr, ethBalanceFetchInterval, ethBalanceUpdateInterval, ethBalanceLowThreshold, arbitrumRPCURL, client))
+	} else {
+		log.Println("Skipping orchestrator ETH balance exporter since LIVEPEER_EXPORTER_ARBITRUM_RPC_URL is not set")
+	}
+	if orchNodeURL != "" {
+		exporters = append(exporters, orch_node_exporter.NewOrchNodeExporter(orchNodeFetchInterval, orchNodeUpdateInterval, orchNodeURL, client))
+	} else {
+		log.Println("Skipping orchestrator node exporter since LIVEPEER_EXPORTER_ORCH_NODE_URL is not set")
+	}
+
+	// Check that each sub-exporter can fetch its data, so that misconfigurations are noticed immediately.
+	if startupSelfTest {
+		log.Println("Running startup self-test...")
+		selfTest(exporters)
+	}
 
 	// Start sub-exporters.
 	log.Println("Starting sub exporters...")
-	go orchInfoExporter.Start()
-	go orchScoreExporter.Start()
-	go orchDelegatorsExporter.Start()
-	go orchTestStreamsExporter.Start()
-	go orchTicketsExporter.Start()
-	go orchRewardsExporter.Start()
-	go cryptoPricesExporter.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, exporter := range exporters {
+		go runner.Supervise(ctx, exporter)
+	}
+	if pushgatewayURL != "" {
+		pusher := push.New(pushgatewayURL, pushJob).
+			Gatherer(allGatherers(exporters, filter)).
+			Grouping("orchestrator", orchAddr).
+			Client(client)
+		go pushMetrics(ctx, pusher, pushInterval)
+	}
+	if ensName != "" {
+		go ens.Watch(ctx, client, ethereumRPCURL, ensName, orchAddr, util.GetEnvInterval("LIVEPEER_EXPORTER_ENS_RESOLVE_INTERVAL", ensResolveIntervalDefault))
+	}
+
+	// Resolve the orchestrator display name.
+	// NOTE: A configured ENS name is used as is. Otherwise the primary ENS name of the address is looked
+	// up when an Ethereum RPC is set. The address is used when no name is available.
+	if filter.name != nil {
+		if ensName != "" {
+			filter.name.set(ensName)
+		} else if ethereumRPCURL != "" {
+			lookup := func() (string, error) { return ens.LookupName(client, ethereumRPCURL, orchAddr) }
+			go watchDisplayName(ctx, filter.name, lookup, orchAddr, util.GetEnvInterval("LIVEPEER_EXPORTER_NAME_REFRESH_INTERVAL", nameRefreshIntervalDefault))
+		}
+	}
+
+	// Create the listener explicitly so that dual-stack vs single-stack binding is predictable.
+	// NOTE: A Unix socket replaces the TCP listener. Its file is removed when the listener is closed.
+	var listener net.Listener
+	if unixSocket != "" {
+		if err := os.Remove(unixSocket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalf("Failed to remove stale socket '%s': %v", unixSocket, err)
+		}
+		listener, err = net.Listen("unix", unixSocket)
+		if err != nil {
+			log.Fatalf("Failed to listen on '%s': %v", unixSocket, err)
+		}
+	} else {
+		listenAddr := util.ListenAddress(bindAddress, port)
+		listener, err = net.Listen(util.ListenNetwork(bindAddress), listenAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on '%s': %v", listenAddr, err)
+		}
+	}
+
+	// Serve the health check on a separate port, if requested.
+	// NOTE: The health server serves plain HTTP without client certificates, since it is meant for the
+	// probes of the orchestrator platform.
+	var healthServer *http.Server
+	if healthPort != "" && (healthPort != port || unixSocket != "") {
+		healthAddr := util.ListenAddress(bindAddress, healthPort)
+		healthListener, err := net.Listen(util.ListenNetwork(bindAddress), healthAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on '%s': %v", healthAddr, err)
+		}
+		healthMux := http.NewServeMux()
+		healthMux.Handle("/healthz", instrumentHandler("/healthz", http.HandlerFunc(healthzHandler)))
+		healthServer = &http.Server{Handler: healthMux}
+		log.Printf("Serving the health check via HTTP on %s/healthz", healthListener.Addr())
+		go func() {
+			if err := healthServer.Serve(healthListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Health server failed: %v", err)
+			}
+		}()
+	}
 
 	// Expose the registered metrics via HTTP.
-	log.Println("Exposing metrics via HTTP on port 9153")
-	http.Handle("/metrics", promhttp.Handler())
-	err = http.ListenAndServe(":9153", nil)
-	if err != nil {
+	log.Printf("Exposing metrics via HTTP on %s%s", listener.Addr(), metricsPath)
+	// NOTE: A dedicated mux is used since importing 'net/http/pprof' registers its handlers on the default mux.
+	// Each handler is instrumented with the path it is registered at.
+	mux := http.NewServeMux()
+	handle := func(pattern string, handler http.Handler) {
+		mux.Handle(pattern, instrumentHandler(pattern, handler))
+	}
+	handle(metricsPath, promhttp.InstrumentHandlerInFlight(metrics.HTTPRequestsInFlight, metricsHandler(exporters, filter, util.ExemplarsEnabled)))
+	handle("/healthz", http.HandlerFunc(healthzHandler))
+	links := []landingPageLink{
+		{Path: metricsPath, Description: "Prometheus metrics"},
+		{Path: "/healthz", Description: "Liveness check"},
+	}
+	// NOTE: The OpenMetrics path always serves the OpenMetrics format from the same gatherers, for
+	// consumers that do not negotiate the format on the metrics path properly. It is only served when
+	// the OpenMetrics format is enabled.
+	if util.ExemplarsEnabled {
+		handle(openMetricsPath, promhttp.InstrumentHandlerInFlight(metrics.HTTPRequestsInFlight, openMetricsHandler(metricsHandler(exporters, filter, true))))
+		links = slices.Insert(links, 1, landingPageLink{Path: openMetricsPath, Description: "Prometheus metrics in the OpenMetrics format"})
+	}
+	if enablePprof {
+		handle("/debug/pprof/", requireTokenHandler(http.HandlerFunc(pprof.Index), debugToken))
+		handle("/debug/pprof/cmdline", requireTokenHandler(http.HandlerFunc(pprof.Cmdline), debugToken))
+		handle("/debug/pprof/profile", requireTokenHandler(http.HandlerFunc(pprof.Profile), debugToken))
+		handle("/debug/pprof/symbol", requireTokenHandler(http.HandlerFunc(pprof.Symbol), debugToken))
+		handle("/debug/pprof/trace", requireTokenHandler(http.HandlerFunc(pprof.Trace), debugToken))
+		links = append(links, landingPageLink{Path: "/debug/pprof/", Description: "Go runtime profiles"})
+	}
+	if enableDebug {
+		// NOTE: Each request queries the subgraph, so only one request is handled at a time.
+		handle("/debug/stake", requireTokenHandler(limitConcurrencyHandler(debugStakeHandler(client, subgraphEndpoint, orchAddr), 1), debugToken))
+		links = append(links, landingPageLink{Path: "/debug/stake", Description: "Orchestrator stake at the round given by the 'round' query parameter"})
+		handle("/debug/config", requireTokenHandler(debugConfigHandler(redactedEnvVars), debugToken))
+		links = append(links, landingPageLink{Path: "/debug/config", Description: "Effective configuration with secrets redacted"})
+	}
+	if eventsToken != "" {
+		handle("/events", eventsHandler(exporters, filter, eventsToken, ctx.Done()))
+		links = append(links, landingPageLink{Path: "/events", Description: "Server-Sent Events with the metrics of each completed exporter update"})
+	}
+	if metricsPath != "/" {
+		handle("/", landingPageHandler(version, basePath, links))
+	}
+	server := &http.Server{
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			MinVersion:   tlsMinVersion,
+			CipherSuites: tlsCipherSuites,
+		},
+	}
+
+	// Authenticate scrapers with client certificates.
+	// NOTE: When '/healthz' is exempted, certificates are only verified if given during the handshake
+	// and required for the other endpoints by the handler instead, since probes may not present one.
+	if tlsClientCAFile != "" {
+		clientCAs, err := util.LoadCertPool(tlsClientCAFile)
+		if err != nil {
+			log.Fatalf("Error loading LIVEPEER_EXPORTER_TLS_CLIENT_CA_FILE: %v", err)
+		}
+		server.TLSConfig.ClientCAs = clientCAs
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if tlsClientCertExemptHealthz {
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			server.Handler = requireClientCertHandler(mux, "/healthz")
+		}
+	}
+
+	// Serve behind a reverse proxy under the path of the external URL.
+	if basePath != "" {
+		server.Handler = basePathHandler(server.Handler, basePath)
+	}
+
+	// Shut the servers down gracefully on SIGINT or SIGTERM.
+	// NOTE: Serve returns as soon as the shutdown starts, so main waits on done until both servers
+	// have finished serving their in-flight requests.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if healthServer != nil {
+			if err := healthServer.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error shutting down health server: %v", err)
+			}
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	if tlsCertFile != "" {
+		err = server.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-done
 }
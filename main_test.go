@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateMetricsPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/metrics", false},
+		{"/livepeer/metrics", false},
+		{"/", false},
+		{"metrics", true},
+		{"", true},
+		{"/openmetrics", true},
+		{"/healthz", true},
+		{"/events", true},
+		{"/debug", true},
+		{"/debug/pprof/", true},
+		{"/debug/config", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if err := validateMetricsPath(tt.path); (err != nil) != tt.wantErr {
+				t.Errorf("validateMetricsPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSecondaryAddress(t *testing.T) {
+	const orchAddr = "0x847791cbf03be716a7fe9dc8c9affe17bd49ae5e"
+	tests := []struct {
+		name            string
+		secondary       string
+		ignoreDuplicate bool
+		want            string
+		wantErr         bool
+	}{
+		{"unset", "", false, "", false},
+		{"different", "0x0A1B2C3D4E5F60718293A4B5C6D7E8F90A1B2C3D", false, "0x0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d", false},
+		{"equal", orchAddr, false, "", true},
+		{"equal different case", "0x847791CBF03BE716A7FE9DC8C9AFFE17BD49AE5E", false, "", true},
+		{"equal ignored", orchAddr, true, "", false},
+		{"equal different case ignored", "0x847791CBF03BE716A7FE9DC8C9AFFE17BD49AE5E", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secondaryAddress(tt.secondary, orchAddr, tt.ignoreDuplicate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secondaryAddress(%q) error = %v, wantErr %v", tt.secondary, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("secondaryAddress(%q) = %q, want %q", tt.secondary, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package metrics defines the metrics the Livepeer exporter exposes about itself.
+package metrics
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// SubgraphHasIndexingErrors indicates whether the Livepeer subgraph reported indexing errors.
+	SubgraphHasIndexingErrors = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_subgraph_has_indexing_errors",
+			Help: "Whether the Livepeer subgraph reported indexing errors in its last response.",
+		},
+	)
+
+	// SubgraphIndexedBlock exposes the block the Livepeer subgraph has indexed up to. Set it with
+	// SetSubgraphIndexedBlock.
+	SubgraphIndexedBlock = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_subgraph_indexed_block",
+			Help: "The block the Livepeer subgraph had indexed up to in its last response.",
+		},
+	)
+
+	// ChainHeadBlock exposes the latest block of the chain. Set it with SetChainHeadBlock.
+	// NOTE: A vector without labels is used so that it is only exposed once the chain head is known.
+	ChainHeadBlock = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_chain_head_block",
+			Help: "The latest block of the chain according to the configured RPC endpoint.",
+		},
+		nil,
+	)
+
+	// SubgraphBlockLag exposes how many blocks the Livepeer subgraph lags behind the chain head.
+	// NOTE: A vector without labels is used so that it is only exposed once the chain head is known.
+	SubgraphBlockLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_subgraph_block_lag",
+			Help: "The number of blocks the Livepeer subgraph lags behind the chain head.",
+		},
+		nil,
+	)
+
+	// PanicsTotal counts the panics that were recovered in the exporter loops.
+	PanicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "livepeer_exporter_panics_total",
+			Help: "The total number of panics recovered per exporter.",
+		},
+		[]string{"exporter"},
+	)
+
+	// RestartsTotal counts the restarts of exporters that exited unexpectedly.
+	RestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "livepeer_exporter_restarts_total",
+			Help: "The total number of restarts per exporter.",
+		},
+		[]string{"exporter"},
+	)
+
+	// UpdatesSkippedTotal counts the fetched states that were replaced by a newer fetch before the
+	// update loop used them.
+	UpdatesSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "livepeer_exporter_updates_skipped_total",
+			Help: "The total number of fetched states per exporter that were replaced by a newer fetch before the metrics were updated.",
+		},
+		[]string{"exporter"},
+	)
+
+	// PushErrorsTotal counts the failed pushes to the Pushgateway.
+	PushErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "livepeer_exporter_push_errors_total",
+			Help: "The total number of failed pushes to the Pushgateway.",
+		},
+	)
+
+	// SubgraphQueryDurationSeconds tracks how long the subgraph takes to answer the GraphQL queries.
+	SubgraphQueryDurationSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "livepeer_exporter_subgraph_query_duration_seconds",
+			Help:    "The duration of the GraphQL queries sent to the Livepeer subgraph, including reading the response.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		},
+	)
+
+	// FetchIntervalSeconds exposes the configured fetch interval of each exporter.
+	FetchIntervalSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_fetch_interval_seconds",
+			Help: "The configured interval at which each exporter fetches its data.",
+		},
+		[]string{"exporter"},
+	)
+
+	// UpdateIntervalSeconds exposes the configured metrics update interval of each exporter.
+	UpdateIntervalSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_update_interval_seconds",
+			Help: "The configured interval at which each exporter updates its metrics.",
+		},
+		[]string{"exporter"},
+	)
+
+	// ConsecutiveFetchFailures exposes the number of fetches of each exporter that failed in a row.
+	ConsecutiveFetchFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_consecutive_fetch_failures",
+			Help: "The number of consecutive failed fetches per exporter. Reset to 0 on a successful fetch.",
+		},
+		[]string{"exporter"},
+	)
+
+	// DataStale reports whether the data of an exporter is stale.
+	DataStale = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_data_stale",
+			Help: "Whether the time since the last successful fetch of the exporter exceeds the staleness threshold.",
+		},
+		[]string{"exporter"},
+	)
+
+	// SecondsToNextFetch exposes the time left until each exporter fetches its data again.
+	SecondsToNextFetch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_seconds_to_next_fetch",
+			Help: "The number of seconds until each exporter fetches its data again, as of its last metrics update.",
+		},
+		[]string{"exporter"},
+	)
+
+	// HTTPRequestsTotal counts the HTTP requests served by the exporter.
+	HTTPRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "livepeer_exporter_http_requests_total",
+			Help: "The total number of HTTP requests served per path and status code.",
+		},
+		[]string{"path", "code"},
+	)
+
+	// HTTPRequestsInFlight tracks the metrics requests that are currently being served.
+	HTTPRequestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_http_requests_in_flight",
+			Help: "The number of metrics requests that are currently being served.",
+		},
+	)
+
+	// Goroutines exposes the number of goroutines so that goroutine leaks in the exporter loops show up.
+	Goroutines = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "livepeer_exporter_goroutines",
+			Help: "The number of goroutines that currently exist.",
+		},
+		func() float64 { return float64(runtime.NumGoroutine()) },
+	)
+
+	// ENSAddressInfo exposes the address the orchestrator ENS name currently resolves to.
+	ENSAddressInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "livepeer_orch_ens_address_info",
+			Help: "The address the orchestrator ENS name resolves to. The value is always 1.",
+		},
+		[]string{"name", "address"},
+	)
+)
+
+// init registers the exporter metrics with Prometheus.
+func init() {
+	prometheus.MustRegister(
+		SubgraphHasIndexingErrors,
+		SubgraphIndexedBlock,
+		ChainHeadBlock,
+		SubgraphBlockLag,
+		PanicsTotal,
+		RestartsTotal,
+		UpdatesSkippedTotal,
+		PushErrorsTotal,
+		SubgraphQueryDurationSeconds,
+		FetchIntervalSeconds,
+		UpdateIntervalSeconds,
+		SecondsToNextFetch,
+		DataStale,
+		ConsecutiveFetchFailures,
+		HTTPRequestsTotal,
+		HTTPRequestsInFlight,
+		Goroutines,
+		ENSAddressInfo,
+	)
+}
+
+// blocks holds the last known chain head and subgraph indexed blocks, which are set by different
+// exporters, so that the lag between them can be calculated.
+var blocks struct {
+	sync.Mutex
+	chainHead       int64
+	subgraphIndexed int64
+}
+
+// SetSubgraphIndexedBlock sets the block the subgraph has indexed up to and updates the lag.
+func SetSubgraphIndexedBlock(number int64) {
+	blocks.Lock()
+	defer blocks.Unlock()
+
+	blocks.subgraphIndexed = number
+	SubgraphIndexedBlock.Set(float64(number))
+	updateSubgraphBlockLag()
+}
+
+// SetChainHeadBlock sets the latest block of the chain and updates the lag.
+func SetChainHeadBlock(number int64) {
+	blocks.Lock()
+	defer blocks.Unlock()
+
+	blocks.chainHead = number
+	ChainHeadBlock.WithLabelValues().Set(float64(number))
+	updateSubgraphBlockLag()
+}
+
+// updateSubgraphBlockLag updates the subgraph block lag once both blocks are known. The lag is
+// never negative, since the chain head can be fetched before a newer subgraph response.
+// NOTE: The caller must hold the blocks lock.
+func updateSubgraphBlockLag() {
+	if blocks.chainHead == 0 || blocks.subgraphIndexed == 0 {
+		return
+	}
+	SubgraphBlockLag.WithLabelValues().Set(float64(max(blocks.chainHead-blocks.subgraphIndexed, 0)))
+}
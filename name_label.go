@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// nameLabelName is the name of the label the orchestrator display name is exposed in.
+const nameLabelName = "name"
+
+// displayName holds the display name of the orchestrator that is added as label to all metrics.
+type displayName struct {
+	value atomic.Value
+}
+
+// newDisplayName returns a displayName that initially holds name.
+func newDisplayName(name string) *displayName {
+	n := &displayName{}
+	n.value.Store(name)
+	return n
+}
+
+// get returns the current display name.
+func (n *displayName) get() string {
+	return n.value.Load().(string)
+}
+
+// set changes the display name.
+func (n *displayName) set(name string) {
+	n.value.Store(name)
+}
+
+// wrap returns a gatherer that adds the display name label to all metrics of gatherer.
+// NOTE: The label is added when gathering rather than as constant label of the metrics, so that it
+// follows the display name when it changes. Metrics that already have a 'name' label are left as is.
+func (n *displayName) wrap(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		labelName, value := nameLabelName, n.get()
+		label := &dto.LabelPair{Name: &labelName, Value: &value}
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				if !hasLabel(metric, nameLabelName) {
+					metric.Label = append(metric.Label, label)
+				}
+			}
+		}
+		return families, err
+	})
+}
+
+// hasLabel reports whether metric has a label with the given name.
+func hasLabel(metric *dto.Metric, name string) bool {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// watchDisplayName refreshes the display name with lookup every interval until ctx is cancelled. The
+// display name falls back to fallback when lookup fails.
+func watchDisplayName(ctx context.Context, name *displayName, lookup func() (string, error), fallback string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		resolved, err := lookup()
+		if err != nil {
+			log.Printf("Error looking up orchestrator display name, using '%s': %v", fallback, err)
+			resolved = fallback
+		}
+		if resolved != name.get() {
+			log.Printf("Orchestrator display name set to '%s'", resolved)
+			name.set(resolved)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
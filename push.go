@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"livepeer-exporter/metrics"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushJob is the Pushgateway job the metrics are pushed under.
+const pushJob = "livepeer_exporter"
+
+// pushMetrics pushes the metrics with pusher every interval until ctx is cancelled. Failed pushes
+// are logged and counted in the 'livepeer_exporter_push_errors_total' metric.
+func pushMetrics(ctx context.Context, pusher *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error pushing metrics to the Pushgateway: %v", err)
+			metrics.PushErrorsTotal.Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"sync"
+	"time"
+)
+
+// updateBufferSize is the number of updates that are buffered per subscriber.
+const updateBufferSize = 16
+
+// Update represents a completed metrics update of an exporter.
+type Update struct {
+	Exporter string
+	Time     time.Time
+}
+
+// Broadcaster broadcasts updates to all subscribers. Updates are dropped for subscribers whose
+// buffer is full, so that a slow subscriber cannot hold up the update loops. The zero value is ready
+// to use.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan Update]struct{}
+}
+
+// Updates is the broadcaster the update loops of Run publish their completed updates to.
+var Updates = &Broadcaster{}
+
+// Subscribe returns a channel that receives the updates published from now on. It should be passed
+// to Unsubscribe when the updates are no longer read.
+func (b *Broadcaster) Subscribe() chan Update {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Update]struct{})
+	}
+	ch := make(chan Update, updateBufferSize)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops sending updates to ch.
+func (b *Broadcaster) Unsubscribe(ch chan Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, ch)
+}
+
+// Publish sends update to all subscribers that have room for it in their buffer.
+func (b *Broadcaster) Publish(update Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package runner
+
+import "time"
+
+// Ticker represents a ticker that delivers ticks on a channel.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Clock provides the current time and tickers, so that the fetch and update loops and the time
+// based metrics can be driven deterministically.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTicker returns a ticker that ticks every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// RealClock is the Clock that uses the system time.
+var RealClock Clock = realClock{}
+
+// realClock implements Clock using the time package.
+type realClock struct{}
+
+// Now returns the current system time.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a time.Ticker that ticks every d.
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker implements Ticker using a time.Ticker.
+type realTicker struct {
+	*time.Ticker
+}
+
+// C returns the channel on which the ticks are delivered.
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,182 @@
+// Package runner runs the fetch and update loops of the sub-exporters and restarts exporters that
+// exited unexpectedly.
+package runner
+
+import (
+	"context"
+	"livepeer-exporter/metrics"
+	"livepeer-exporter/util"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StaleAfterIntervals is the number of fetch intervals after the last successful fetch at which the
+// data of an exporter is reported as stale in the 'livepeer_exporter_data_stale' metric.
+var StaleAfterIntervals = 3.0
+
+// Restart backoff settings used by Supervise.
+const (
+	minRestartBackoff = 5 * time.Second
+	maxRestartBackoff = 5 * time.Minute
+)
+
+// prefetched contains the names of the exporters whose data was fetched before they were started.
+var prefetched sync.Map
+
+// MarkFetched records that the data of the exporter was just fetched, e.g. by a startup self-test,
+// so that the next Run of the exporter skips its initial fetch instead of fetching the data again.
+func MarkFetched(exporter string) {
+	prefetched.Store(exporter, true)
+}
+
+// Exporter represents a sub-exporter that can be supervised.
+type Exporter interface {
+	// Name returns the name that identifies the exporter in logs and metrics.
+	Name() string
+	// Start runs the exporter until ctx is cancelled.
+	Start(ctx context.Context)
+}
+
+// Loop represents a function that is called on a fixed interval.
+type Loop struct {
+	Interval time.Duration
+	Fn       func()
+}
+
+// tick calls fn every interval of clock until ctx is cancelled.
+func tick(ctx context.Context, clock Clock, interval time.Duration, fn func()) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			fn()
+		}
+	}
+}
+
+// Run fetches the initial data and updates the metrics, after which it runs the fetch and update
+// loops until ctx is cancelled. The initial fetch is skipped when the data was marked as fetched
+// with MarkFetched. Data that should be fetched on a different interval can be passed
+// as extra fetch loops. When any loop panics the other loops are stopped and Run returns, so that
+// the exporter can be restarted by Supervise. The fetch function reports whether the data was
+// fetched. The time left until the next fetch and whether the data is stale are published on every
+// update, after which the update is broadcast on Updates. The loops are driven by clock.
+//
+// The update loop always works on the latest fetched data. Fetches are never queued: when several
+// fetches complete between two updates, only the last one is used and the others are counted in the
+// 'livepeer_exporter_updates_skipped_total' metric. Likewise, ticks of a slow loop are dropped rather
+// than queued.
+func Run(ctx context.Context, clock Clock, exporter string, fetchInterval time.Duration, updateInterval time.Duration, fetch func() bool, update func(), extraFetches ...Loop) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Publish the configured intervals.
+	metrics.FetchIntervalSeconds.WithLabelValues(exporter).Set(fetchInterval.Seconds())
+	metrics.UpdateIntervalSeconds.WithLabelValues(exporter).Set(updateInterval.Seconds())
+
+	// Track when the next fetch is scheduled.
+	var nextFetch atomic.Int64
+	scheduleFetch := func() {
+		nextFetch.Store(clock.Now().Add(fetchInterval).UnixNano())
+	}
+	// Track when the data was last fetched successfully and how many fetches failed since.
+	// NOTE: The start time counts as a successful fetch, so that the data is not reported as stale
+	// before the first fetch had a chance to succeed.
+	var lastSuccess atomic.Int64
+	lastSuccess.Store(clock.Now().UnixNano())
+	staleAfter := time.Duration(StaleAfterIntervals * float64(fetchInterval))
+	var consecutiveFailures atomic.Int64
+	trackedFetch := func() {
+		if fetch() {
+			lastSuccess.Store(clock.Now().UnixNano())
+			consecutiveFailures.Store(0)
+		} else {
+			consecutiveFailures.Add(1)
+		}
+		metrics.ConsecutiveFetchFailures.WithLabelValues(exporter).Set(float64(consecutiveFailures.Load()))
+	}
+
+	// Track the fetches that completed since the last update.
+	var pendingFetches atomic.Int64
+	scheduledFetch := func() {
+		scheduleFetch()
+		trackedFetch()
+		pendingFetches.Add(1)
+	}
+	scheduledUpdate := func() {
+		if skipped := pendingFetches.Swap(0) - 1; skipped > 0 {
+			metrics.UpdatesSkippedTotal.WithLabelValues(exporter).Add(float64(skipped))
+		}
+		update()
+		now := clock.Now()
+		secondsToNextFetch := time.Unix(0, nextFetch.Load()).Sub(now).Seconds()
+		metrics.SecondsToNextFetch.WithLabelValues(exporter).Set(max(secondsToNextFetch, 0))
+		stale := now.Sub(time.Unix(0, lastSuccess.Load())) > staleAfter
+		metrics.DataStale.WithLabelValues(exporter).Set(util.BoolToFloat64(stale))
+		Updates.Publish(Update{Exporter: exporter, Time: now})
+	}
+
+	// Fetch initial data and update metrics.
+	// NOTE: The mark is consumed, so that restarts by Supervise fetch the data again.
+	if util.RunWithRecover(exporter, func() {
+		if _, ok := prefetched.LoadAndDelete(exporter); !ok {
+			trackedFetch()
+		}
+		for _, l := range extraFetches {
+			l.Fn()
+		}
+		scheduleFetch()
+		scheduledUpdate()
+	}) {
+		return
+	}
+
+	// Start the fetch and update loops in goroutines.
+	var wg sync.WaitGroup
+	loops := append([]Loop{{fetchInterval, scheduledFetch}, {updateInterval, scheduledUpdate}}, extraFetches...)
+	for _, l := range loops {
+		wg.Add(1)
+		go func(l Loop) {
+			defer wg.Done()
+			defer cancel()
+
+			util.RunWithRecover(exporter, func() {
+				tick(ctx, clock, l.Interval, l.Fn)
+			})
+		}(l)
+	}
+	wg.Wait()
+}
+
+// Supervise starts the exporter and restarts it with an exponential backoff whenever it exits
+// before ctx is cancelled. Restarts are counted in the 'livepeer_exporter_restarts_total' metric.
+func Supervise(ctx context.Context, exporter Exporter) {
+	backoff := minRestartBackoff
+	for {
+		started := time.Now()
+		exporter.Start(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Reset the backoff when the exporter ran fine for a while.
+		if time.Since(started) > maxRestartBackoff {
+			backoff = minRestartBackoff
+		}
+
+		log.Printf("%s exporter exited unexpectedly, restarting in %v", exporter.Name(), backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		metrics.RestartsTotal.WithLabelValues(exporter.Name()).Inc()
+		backoff = min(backoff*2, maxRestartBackoff)
+	}
+}
@@ -0,0 +1,158 @@
+package runner_test
+
+import (
+	"context"
+	"livepeer-exporter/runner"
+	"livepeer-exporter/testutil"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testExporter is a runner.Exporter that counts its starts and returns from Start immediately.
+type testExporter struct {
+	starts atomic.Int64
+}
+
+// Name returns the name of the exporter.
+func (e *testExporter) Name() string {
+	return "test"
+}
+
+// Start counts the start and returns, as if the exporter exited unexpectedly.
+func (e *testExporter) Start(ctx context.Context) {
+	e.starts.Add(1)
+}
+
+func TestRunStopsLoopsOnCancel(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	clock := testutil.NewFakeClock(time.Unix(1704067200, 0))
+	var fetches, updates, extraFetches atomic.Int64
+	fetch := func() bool {
+		fetches.Add(1)
+		return true
+	}
+	update := func() { updates.Add(1) }
+	extra := runner.Loop{Interval: time.Hour, Fn: func() { extraFetches.Add(1) }}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(ctx, clock, "test", time.Minute, 15*time.Second, fetch, update, extra)
+	}()
+
+	// The initial fetch and update run before the loops start.
+	testutil.Eventually(t, func() bool { return clock.Tickers() == 3 }, "loops to start")
+	if fetches.Load() != 1 || updates.Load() != 1 || extraFetches.Load() != 1 {
+		t.Fatalf("initial run: fetches = %d, updates = %d, extra fetches = %d, want 1 each", fetches.Load(), updates.Load(), extraFetches.Load())
+	}
+
+	// Each loop runs on its own interval.
+	clock.Advance(15 * time.Second)
+	testutil.Eventually(t, func() bool { return updates.Load() == 2 }, "update after update interval")
+	clock.Advance(45 * time.Second)
+	testutil.Eventually(t, func() bool { return fetches.Load() == 2 }, "fetch after fetch interval")
+
+	// Cancelling stops all loops and their tickers.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+	if n := clock.Tickers(); n != 0 {
+		t.Errorf("%d tickers are still running", n)
+	}
+	testutil.Eventually(t, func() bool { return runtime.NumGoroutine() <= goroutines }, "loop goroutines to exit")
+}
+
+func TestRunReturnsWhenLoopPanics(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Unix(1704067200, 0))
+	var updates atomic.Int64
+	update := func() {
+		if updates.Add(1) > 1 {
+			panic("update failed")
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(context.Background(), clock, "test", time.Minute, 15*time.Second, func() bool { return true }, update)
+	}()
+	testutil.Eventually(t, func() bool { return clock.Tickers() == 2 }, "loops to start")
+
+	clock.Advance(15 * time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after a loop panicked")
+	}
+	if n := clock.Tickers(); n != 0 {
+		t.Errorf("%d tickers are still running", n)
+	}
+}
+
+func TestSuperviseStopsOnCancel(t *testing.T) {
+	exporter := &testExporter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Supervise(ctx, exporter)
+	}()
+
+	// The exporter exits immediately, so Supervise waits for the restart backoff.
+	testutil.Eventually(t, func() bool { return exporter.starts.Load() == 1 }, "exporter to start")
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Supervise did not return after the context was cancelled")
+	}
+	if n := exporter.starts.Load(); n != 1 {
+		t.Errorf("exporter was started %d times, want 1", n)
+	}
+}
+
+func TestRunSkipsInitialFetchWhenMarkedFetched(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Unix(1704067200, 0))
+	var fetches, updates atomic.Int64
+	fetch := func() bool {
+		fetches.Add(1)
+		return true
+	}
+	update := func() { updates.Add(1) }
+
+	// run starts Run and returns a function that stops it once the loops started.
+	run := func() func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			runner.Run(ctx, clock, "prefetched", time.Minute, 15*time.Second, fetch, update)
+		}()
+		testutil.Eventually(t, func() bool { return clock.Tickers() == 2 }, "loops to start")
+		return func() {
+			cancel()
+			<-done
+		}
+	}
+
+	// The data fetched before the start is used for the initial update instead of fetching it again.
+	runner.MarkFetched("prefetched")
+	stop := run()
+	if fetches.Load() != 0 || updates.Load() != 1 {
+		t.Errorf("initial run: fetches = %d, updates = %d, want 0 fetches and 1 update", fetches.Load(), updates.Load())
+	}
+	stop()
+
+	// The mark only applies to the first run, so that a restart fetches the data again.
+	stop = run()
+	if fetches.Load() != 1 {
+		t.Errorf("restart: fetches = %d, want 1", fetches.Load())
+	}
+	stop()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"livepeer-exporter/runner"
+	"log"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// selfTestResult represents the result of the startup self-test of a sub-exporter.
+type selfTestResult struct {
+	exporter string
+	ok       bool
+	latency  time.Duration
+}
+
+// selfTest fetches the data of each sub-exporter once, concurrently, and logs a summary of which
+// fetches succeeded and how long they took, so that misconfigured addresses or endpoints are
+// noticed immediately. The errors of failed fetches are logged by the sub-exporters themselves. The
+// sub-exporters whose fetch succeeded are marked as fetched, so that they do not fetch the same data
+// again when they are started.
+func selfTest(exporters []subExporter) {
+	results := make([]selfTestResult, len(exporters))
+	var wg sync.WaitGroup
+	for i, exporter := range exporters {
+		wg.Add(1)
+		go func(i int, exporter subExporter) {
+			defer wg.Done()
+			started := time.Now()
+			ok := exporter.Fetch()
+			results[i] = selfTestResult{exporter.Name(), ok, time.Since(started)}
+		}(i, exporter)
+	}
+	wg.Wait()
+
+	var summary strings.Builder
+	w := tabwriter.NewWriter(&summary, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXPORTER\tRESULT\tLATENCY")
+	failed := 0
+	for _, result := range results {
+		status := "ok"
+		if result.ok {
+			runner.MarkFetched(result.exporter)
+		} else {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\n", result.exporter, status, result.latency.Round(time.Millisecond))
+	}
+	w.Flush()
+
+	log.Printf("Startup self-test results (%d of %d failed):", failed, len(results))
+	for _, line := range strings.Split(strings.TrimSuffix(summary.String(), "\n"), "\n") {
+		log.Printf("  %s", line)
+	}
+}
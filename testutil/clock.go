@@ -0,0 +1,105 @@
+package testutil
+
+import (
+	"livepeer-exporter/runner"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock is a runner.Clock whose time only moves when it is advanced, so that the fetch and update
+// loops can be driven deterministically.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// fakeTicker is a runner.Ticker that ticks when its FakeClock is advanced past its next tick.
+type fakeTicker struct {
+	clock  *FakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFakeClock returns a FakeClock that is set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker that ticks every d of clock time.
+func (c *FakeClock) NewTicker(d time.Duration) runner.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and ticks the tickers whose next tick was reached. Like a
+// time.Ticker, a tick is dropped when the previous tick was not received yet.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if c.now.Before(t.next) {
+			continue
+		}
+		for !c.now.Before(t.next) {
+			t.next = t.next.Add(t.period)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+// Tickers returns the number of tickers that were created and not stopped yet.
+func (c *FakeClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+// C returns the channel on which the ticks are delivered.
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop turns off the ticker.
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, ticker := range t.clock.tickers {
+		if ticker == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Eventually fails the test when condition does not become true within a few seconds.
+func Eventually(t testing.TB, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", message)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
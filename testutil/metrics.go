@@ -0,0 +1,53 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Value returns the value of the single gauge or counter collected by collector.
+func Value(t testing.TB, collector prometheus.Collector) float64 {
+	t.Helper()
+
+	metrics := make(chan prometheus.Metric, 1)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	var values []float64
+	for metric := range metrics {
+		written := &dto.Metric{}
+		if err := metric.Write(written); err != nil {
+			t.Fatalf("error writing metric: %v", err)
+		}
+		switch {
+		case written.Gauge != nil:
+			values = append(values, written.Gauge.GetValue())
+		case written.Counter != nil:
+			values = append(values, written.Counter.GetValue())
+		}
+	}
+	if len(values) != 1 {
+		t.Fatalf("collected %d gauge or counter values, want 1", len(values))
+	}
+	return values[0]
+}
+
+// Count returns the number of metrics collected by collector, e.g. the number of label values of a
+// vector.
+func Count(collector prometheus.Collector) int {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	count := 0
+	for range metrics {
+		count++
+	}
+	return count
+}
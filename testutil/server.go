@@ -0,0 +1,123 @@
+// Package testutil provides helpers to test the exporters against canned API responses. The
+// responses are stored as fixtures in the 'testdata' directory at the root of the repository.
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// OrchAddress is the orchestrator address the fixtures were created for.
+const OrchAddress = "0x847791cbf03be716a7fe9dc8c9affe17bd49ae5e"
+
+// Route describes a response of the Server and the requests it is served for. A request matches a
+// route when its path, query parameters and body match. Empty fields match any request.
+type Route struct {
+	Path     string            // The URL path of the request.
+	Query    map[string]string // The query parameters the request must contain.
+	Contains string            // A string the request body must contain, e.g. a GraphQL field.
+	Status   int               // The status code of the response. Defaults to 200.
+	Fixture  string            // The fixture in the 'testdata' directory to respond with.
+	Body     string            // The body to respond with when no fixture is set.
+}
+
+// matches reports whether the request with the given body matches the route.
+func (r Route) matches(req *http.Request, body string) bool {
+	if r.Path != "" && req.URL.Path != r.Path {
+		return false
+	}
+	for key, value := range r.Query {
+		if req.URL.Query().Get(key) != value {
+			return false
+		}
+	}
+	return strings.Contains(body, r.Contains)
+}
+
+// Server is a HTTP server that responds to requests with the first of its routes that matches.
+// Requests that match none of the routes are responded to with a 404.
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	routes []Route
+	hits   map[string]int // The number of requests per URL path.
+}
+
+// NewServer starts a Server with the given routes. The fixtures of the routes are loaded up front
+// so that missing fixtures fail the test immediately. The server is closed when the test finishes.
+func NewServer(t testing.TB, routes ...Route) *Server {
+	t.Helper()
+
+	s := &Server{hits: make(map[string]int)}
+	s.SetRoutes(t, routes...)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetRoutes replaces the routes of the server, e.g. to simulate a change of the upstream data.
+func (s *Server) SetRoutes(t testing.TB, routes ...Route) {
+	t.Helper()
+
+	loaded := make([]Route, len(routes))
+	for i, route := range routes {
+		if route.Fixture != "" {
+			route.Body = string(Fixture(t, route.Fixture))
+		}
+		loaded[i] = route
+	}
+
+	s.mu.Lock()
+	s.routes = loaded
+	s.mu.Unlock()
+}
+
+// Hits returns the number of requests the server received for the given URL path.
+func (s *Server) Hits(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+// serveHTTP responds to the request with the first route that matches it.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	routes := s.routes
+	s.mu.Unlock()
+
+	for _, route := range routes {
+		if !route.matches(r, string(body)) {
+			continue
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if route.Status != 0 {
+			w.WriteHeader(route.Status)
+		}
+		io.WriteString(w, route.Body)
+		return
+	}
+	http.Error(w, "no route for "+r.URL.String(), http.StatusNotFound)
+}
+
+// Fixture returns the contents of the fixture with the given name from the 'testdata' directory.
+func Fixture(t testing.TB, name string) []byte {
+	t.Helper()
+
+	_, file, _, _ := runtime.Caller(0)
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	return data
+}
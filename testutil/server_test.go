@@ -0,0 +1,96 @@
+package testutil
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// get sends a request to the server and returns the status code and body of the response.
+func get(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestServerRoutes(t *testing.T) {
+	s := NewServer(t,
+		Route{Path: "/graphql", Contains: "delegators", Body: "delegators"},
+		Route{Path: "/graphql", Body: "other"},
+		Route{Path: "/prices", Query: map[string]string{"date": "2024-01-01"}, Body: "price"},
+		Route{Path: "/down", Status: http.StatusServiceUnavailable, Body: "down"},
+	)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"body match", http.MethodPost, "/graphql", `{"query":"{ delegators { id } }"}`, http.StatusOK, "delegators"},
+		{"first match wins", http.MethodPost, "/graphql", `{"query":"{ transcoder { id } }"}`, http.StatusOK, "other"},
+		{"query match", http.MethodGet, "/prices?date=2024-01-01", "", http.StatusOK, "price"},
+		{"query mismatch", http.MethodGet, "/prices?date=2024-01-02", "", http.StatusNotFound, ""},
+		{"status", http.MethodGet, "/down", "", http.StatusServiceUnavailable, "down"},
+		{"unknown path", http.MethodGet, "/unknown", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := get(t, tt.method, s.URL+tt.path, tt.body)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	if got := s.Hits("/graphql"); got != 2 {
+		t.Errorf("Hits(/graphql) = %d, want 2", got)
+	}
+}
+
+func TestServerSetRoutes(t *testing.T) {
+	s := NewServer(t, Route{Body: "before"})
+	s.SetRoutes(t, Route{Body: "after"})
+
+	if _, body := get(t, http.MethodGet, s.URL, ""); body != "after" {
+		t.Errorf("body = %q, want %q", body, "after")
+	}
+}
+
+func TestFixturesAreValidJSON(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures found")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(data) {
+			t.Errorf("fixture %s is not valid JSON", filepath.Base(file))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// effectiveEnv records the value each environment variable read through the GetEnv functions took
+// effect with, including defaults.
+var effectiveEnv = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// recordEnv records the value the environment variable key took effect with.
+func recordEnv(key string, value any) {
+	effectiveEnv.Lock()
+	defer effectiveEnv.Unlock()
+	effectiveEnv.values[key] = fmt.Sprint(value)
+}
+
+// EffectiveEnv returns the value each environment variable read through the GetEnv functions took
+// effect with. The values of the redacted variables are replaced with 'REDACTED' when set.
+func EffectiveEnv(redacted []string) map[string]string {
+	effectiveEnv.Lock()
+	defer effectiveEnv.Unlock()
+
+	values := maps.Clone(effectiveEnv.values)
+	for _, key := range redacted {
+		if values[key] != "" {
+			values[key] = "REDACTED"
+		}
+	}
+	return values
+}
+
+// UnknownEnv returns the names of the set environment variables with the given prefix that were not
+// read through the GetEnv functions. These usually are misspelled variables whose default was used
+// instead.
+func UnknownEnv(prefix string) []string {
+	effectiveEnv.Lock()
+	defer effectiveEnv.Unlock()
+
+	var unknown []string
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if _, ok := effectiveEnv.values[key]; strings.HasPrefix(key, prefix) && !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
@@ -0,0 +1,17 @@
+package util
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// ExemplarsEnabled controls whether AddWithExemplar attaches exemplars. Exemplars are only exposed
+// in the OpenMetrics format, so they should only be enabled together with it.
+var ExemplarsEnabled bool
+
+// AddWithExemplar adds value to counter. When ExemplarsEnabled is set, the exemplar labels (e.g. the
+// hash of the transaction that caused the increase) are attached to the increase.
+func AddWithExemplar(counter prometheus.Counter, value float64, exemplar prometheus.Labels) {
+	if adder, ok := counter.(prometheus.ExemplarAdder); ok && ExemplarsEnabled {
+		adder.AddWithExemplar(value, exemplar)
+		return
+	}
+	counter.Add(value)
+}
@@ -9,12 +9,16 @@ import (
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
-	"livepeer-exporter/constants"
+	"livepeer-exporter/metrics"
 )
 
 // BoolToFloat64 converts a bool to a float64.
@@ -32,19 +36,35 @@ func Round(value float64, decimals int) float64 {
 	return math.Round(value*shift) / shift
 }
 
-// StringToFloat64 parses a string to a float64.
+// TokenDecimals is the number of decimal places token and ETH amounts are rounded to by
+// RoundTokenAmount. A negative value keeps full precision.
+var TokenDecimals = -1
+
+// MaxTokenDecimals is the largest number of decimal places TokenDecimals may be set to, which are the
+// decimals of LPT and ETH.
+const MaxTokenDecimals = 18
+
+// RoundTokenAmount rounds a token or ETH amount to TokenDecimals decimal places.
+func RoundTokenAmount(amount float64) float64 {
+	if TokenDecimals < 0 {
+		return amount
+	}
+	return Round(amount, TokenDecimals)
+}
+
+// StringToFloat64 parses a string, or a Numeric, to a float64.
 // If the string cannot be parsed, it returns an error.
-func StringToFloat64(s string) (float64, error) {
-	f, err := strconv.ParseFloat(s, 64)
+func StringToFloat64[S ~string](s S) (float64, error) {
+	f, err := strconv.ParseFloat(string(s), 64)
 	if err != nil {
 		log.Printf("Error parsing value %v: %v", s, err)
 	}
 	return f, err
 }
 
-// SetFloatFromStr sets the value of a float64 pointer from a string.
+// SetFloatFromStr sets the value of a float64 pointer from a string or a Numeric.
 // If the string cannot be parsed to a float64, it logs an error and returns.
-func SetFloatFromStr(dest *float64, source string) {
+func SetFloatFromStr[S ~string](dest *float64, source S) {
 	temp, err := StringToFloat64(source)
 	if err != nil {
 		log.Printf("Error parsing string to float: %v", err)
@@ -53,8 +73,32 @@ func SetFloatFromStr(dest *float64, source string) {
 	*dest = temp
 }
 
+// SetTokenAmountFromStr sets the value of a float64 pointer from a string containing a token or
+// ETH amount, rounded by RoundTokenAmount.
+func SetTokenAmountFromStr[S ~string](dest *float64, source S) {
+	SetFloatFromStr(dest, source)
+	*dest = RoundTokenAmount(*dest)
+}
+
+// RunWithRecover runs fn and recovers from any panic it raises. A recovered panic is logged together
+// with its stack trace and counted in the 'livepeer_exporter_panics_total' metric. It reports whether
+// fn panicked.
+func RunWithRecover(exporter string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: %s exporter panicked: %v\n%s", exporter, r, debug.Stack())
+			metrics.PanicsTotal.WithLabelValues(exporter).Inc()
+			panicked = true
+		}
+	}()
+
+	fn()
+	return false
+}
+
 // getEnvVarDuration retrieves a duration from an environment variable.
-func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+func GetEnvDuration(key string, defaultValue time.Duration) (value time.Duration) {
+	defer func() { recordEnv(key, value) }()
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
 		return defaultValue
@@ -66,6 +110,114 @@ func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	return value
 }
 
+// MinInterval is the shortest fetch or update interval GetEnvInterval allows.
+const MinInterval = 1 * time.Second
+
+// GetEnvInterval retrieves a fetch or update interval from an environment variable. Intervals shorter
+// than MinInterval are raised to MinInterval with a warning, so that the loops cannot spin the CPU.
+func GetEnvInterval(key string, defaultValue time.Duration) (value time.Duration) {
+	defer func() { recordEnv(key, value) }()
+	value = GetEnvDuration(key, defaultValue)
+	if value < MinInterval {
+		log.Printf("WARNING: '%s' environment variable '%v' is shorter than the minimum interval, using %v instead", key, value, MinInterval)
+		return MinInterval
+	}
+	return value
+}
+
+// GetEnvFloat retrieves a float from an environment variable.
+func GetEnvFloat(key string, defaultValue float64) (value float64) {
+	defer func() { recordEnv(key, value) }()
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		log.Fatalf("failed to parse '%s' environment variable: %v", key, err)
+	}
+	return value
+}
+
+// GetEnvInt retrieves an integer from an environment variable.
+func GetEnvInt(key string, defaultValue int) (value int) {
+	defer func() { recordEnv(key, value) }()
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		log.Fatalf("failed to parse '%s' environment variable: %v", key, err)
+	}
+	return value
+}
+
+// GetEnvString retrieves a string from an environment variable.
+func GetEnvString(key string, defaultValue string) (value string) {
+	defer func() { recordEnv(key, value) }()
+	value = os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvList retrieves a comma-separated list from an environment variable. Empty items are dropped.
+func GetEnvList(key string) (items []string) {
+	defer func() { recordEnv(key, strings.Join(items, ",")) }()
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetEnvBool retrieves a bool from an environment variable.
+func GetEnvBool(key string, defaultValue bool) (value bool) {
+	defer func() { recordEnv(key, value) }()
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Fatalf("failed to parse '%s' environment variable: %v", key, err)
+	}
+	return value
+}
+
+// ListenAddress joins a bind address and port into an address that can be passed to net.Listen.
+// IPv6 literals may be given with or without brackets (e.g. '::1' or '[::1]').
+func ListenAddress(bind string, port string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]")
+	return net.JoinHostPort(host, port)
+}
+
+// ListenNetwork returns the network net.Listen should use for a bind address.
+// An empty bind address, a hostname or the IPv6 unspecified address ('::') listen dual-stack
+// ("tcp"), while explicit IPv4 and IPv6 literals listen single-stack ("tcp4" or "tcp6").
+func ListenNetwork(bind string) string {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]"))
+	switch {
+	case ip == nil, ip.Equal(net.IPv6unspecified):
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// addressRegexp matches a hex encoded Ethereum address.
+var addressRegexp = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// IsAddress checks if a given string is a hex encoded Ethereum address (e.g. '0x1234...').
+func IsAddress(s string) bool {
+	return addressRegexp.MatchString(s)
+}
+
 // graphQLRequest represents the structure of the GraphQL API request used in IsOrchestrator.
 type GraphQLRequest struct {
 	Query string `json:"query"`
@@ -80,8 +232,33 @@ type graphQLResponse struct {
 	}
 }
 
-// sendGraphQLRequest sends a GraphQL request and returns the response body.
-func sendGraphQLRequest(query string) ([]byte, error) {
+// ErrGraphQLErrors is returned when a GraphQL API response contains a non-empty top-level 'errors' array.
+var ErrGraphQLErrors = errors.New("GraphQL response contains errors")
+
+// graphQLErrorsResponse represents the top-level 'errors' array of a GraphQL API response.
+type graphQLErrorsResponse struct {
+	Errors []struct {
+		Message string
+	}
+}
+
+// CheckGraphQLErrors returns an error that wraps ErrGraphQLErrors and contains the first error
+// message when the GraphQL API response body contains errors. GraphQL APIs report failed queries
+// with a 200 status code, so without this check the zeroed data of a failed query would be used.
+func CheckGraphQLErrors(body []byte) error {
+	var response graphQLErrorsResponse
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Errors) == 0 {
+		return nil
+	}
+	if len(response.Errors) > 1 {
+		return fmt.Errorf("%w: %s (and %d more)", ErrGraphQLErrors, response.Errors[0].Message, len(response.Errors)-1)
+	}
+	return fmt.Errorf("%w: %s", ErrGraphQLErrors, response.Errors[0].Message)
+}
+
+// sendGraphQLRequest sends a GraphQL request to the given endpoint and returns the response body.
+// Responses that contain GraphQL errors are returned as error.
+func sendGraphQLRequest(client *http.Client, endpoint string, query string) ([]byte, error) {
 	request := GraphQLRequest{
 		Query: query,
 	}
@@ -91,40 +268,43 @@ func sendGraphQLRequest(query string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := http.Post(constants.LivePeerSubgraphEndpoint, "application/json", bytes.NewBuffer(body))
+	resp, err := client.Post(endpoint, "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request to '%s': %w", endpoint, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("received non-OK response from GraphQL endpoint")
+		return nil, fmt.Errorf("received non-200 status code from '%s': %d", endpoint, resp.StatusCode)
 	}
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body from '%s': %w", endpoint, err)
+	}
+	if err := CheckGraphQLErrors(responseBody); err != nil {
+		return nil, fmt.Errorf("query to '%s' failed: %w", endpoint, err)
 	}
 
 	return responseBody, nil
 }
 
 // IsOrchestrator checks if a given address is an Livepeer orchestrator.
-func IsOrchestrator(id string) (bool, error) {
+func IsOrchestrator(client *http.Client, endpoint string, id string) (bool, error) {
 	query := fmt.Sprintf(`{
         transcoder(id: "%s") {
             __typename
         }
     }`, id)
 
-	responseBody, err := sendGraphQLRequest(query)
+	responseBody, err := sendGraphQLRequest(client, endpoint, query)
 	if err != nil {
 		return false, err
 	}
 
 	var response graphQLResponse
 	if err := json.Unmarshal(responseBody, &response); err != nil {
-		return false, fmt.Errorf("failed to decode response: %w", err)
+		return false, fmt.Errorf("failed to decode response from '%s': %w", endpoint, err)
 	}
 
 	return response.Data.Transcoder.Typename == "Transcoder", nil
@@ -140,22 +320,59 @@ type delegatorResponse struct {
 }
 
 // IsDelegator checks if a given address is an Livepeer delegator.
-func IsDelegator(id string) (bool, error) {
+func IsDelegator(client *http.Client, endpoint string, id string) (bool, error) {
 	query := fmt.Sprintf(`{
         delegator(id: "%s") {
             __typename
         }
     }`, id)
 
-	responseBody, err := sendGraphQLRequest(query)
+	responseBody, err := sendGraphQLRequest(client, endpoint, query)
 	if err != nil {
 		return false, err
 	}
 
 	var response delegatorResponse
 	if err := json.Unmarshal(responseBody, &response); err != nil {
-		return false, fmt.Errorf("failed to decode response: %w", err)
+		return false, fmt.Errorf("failed to decode response from '%s': %w", endpoint, err)
 	}
 
 	return response.Data.Delegator.Typename == "Delegator", nil
 }
+
+// poolResponse represents the structure of the GraphQL API response used in GetStakeAtRound.
+type poolResponse struct {
+	Data struct {
+		Pool *struct {
+			TotalStake string
+		}
+	}
+}
+
+// ErrPoolNotFound is returned by GetStakeAtRound when the orchestrator has no pool in the given round.
+var ErrPoolNotFound = errors.New("no pool found for round")
+
+// GetStakeAtRound retrieves the total stake of a Livepeer orchestrator as recorded in the
+// orchestrator's reward pool of the given round.
+func GetStakeAtRound(client *http.Client, endpoint string, id string, round int) (float64, error) {
+	query := fmt.Sprintf(`{
+        pool(id: "%s-%d") {
+            totalStake
+        }
+    }`, id, round)
+
+	responseBody, err := sendGraphQLRequest(client, endpoint, query)
+	if err != nil {
+		return 0, err
+	}
+
+	var response poolResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return 0, fmt.Errorf("failed to decode response from '%s': %w", endpoint, err)
+	}
+	if response.Data.Pool == nil {
+		return 0, ErrPoolNotFound
+	}
+
+	return StringToFloat64(response.Data.Pool.TotalStake)
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		bind        string
+		wantAddress string
+		wantNetwork string
+	}{
+		{"", ":9153", "tcp"},
+		{"0.0.0.0", "0.0.0.0:9153", "tcp4"},
+		{"127.0.0.1", "127.0.0.1:9153", "tcp4"},
+		{"::", "[::]:9153", "tcp"},
+		{"[::]", "[::]:9153", "tcp"},
+		{"::1", "[::1]:9153", "tcp6"},
+		{"[::1]", "[::1]:9153", "tcp6"},
+		{"localhost", "localhost:9153", "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bind, func(t *testing.T) {
+			address := ListenAddress(tt.bind, "9153")
+			if address != tt.wantAddress {
+				t.Errorf("ListenAddress(%q) = %q, want %q", tt.bind, address, tt.wantAddress)
+			}
+			if network := ListenNetwork(tt.bind); network != tt.wantNetwork {
+				t.Errorf("ListenNetwork(%q) = %q, want %q", tt.bind, network, tt.wantNetwork)
+			}
+		})
+	}
+}
+
+func TestListenAddressListens(t *testing.T) {
+	for _, bind := range []string{"", "127.0.0.1"} {
+		listener, err := net.Listen(ListenNetwork(bind), ListenAddress(bind, "0"))
+		if err != nil {
+			t.Fatalf("error listening on %q: %v", bind, err)
+		}
+		listener.Close()
+	}
+}
+
+func TestCheckGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"data", `{"data":{"protocol":{"id":"0"}}}`, ""},
+		{"empty errors", `{"data":{"protocol":{"id":"0"}},"errors":[]}`, ""},
+		{"not json", `upstream error`, ""},
+		{"errors only", `{"errors":[{"message":"indexing error"}]}`, "GraphQL response contains errors: indexing error"},
+		{"data and errors", `{"data":{"protocol":null},"errors":[{"message":"store error"}]}`, "GraphQL response contains errors: store error"},
+		{"several errors", `{"errors":[{"message":"first"},{"message":"second"},{"message":"third"}]}`, "GraphQL response contains errors: first (and 2 more)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckGraphQLErrors([]byte(tt.body))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckGraphQLErrors() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("CheckGraphQLErrors() = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrGraphQLErrors) {
+				t.Errorf("CheckGraphQLErrors() = %v, want error wrapping ErrGraphQLErrors", err)
+			}
+		})
+	}
+}
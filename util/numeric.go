@@ -0,0 +1,34 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Numeric is a number that is decoded from either a JSON string or a JSON number. The subgraph
+// returns big numbers as strings and smaller numbers as numbers, so decoding into a plain string or
+// float64 field fails depending on the field. The textual representation is kept, so that big
+// numbers do not lose precision before they are parsed.
+type Numeric string
+
+// UnmarshalJSON decodes a Numeric from a JSON string or number.
+func (n *Numeric) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*n = Numeric(s)
+		return nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("failed to decode '%s' as a number: %w", data, err)
+	}
+	*n = Numeric(number)
+	return nil
+}
+
+// Float64 parses the number to a float64.
+func (n Numeric) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
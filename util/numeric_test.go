@@ -0,0 +1,57 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNumericUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json         string
+		wantNumeric  Numeric
+		wantFloat    float64
+		wantErr      bool // Whether decoding fails.
+		wantParseErr bool // Whether parsing the decoded value fails.
+	}{
+		{`"1.5"`, "1.5", 1.5, false, false},
+		{`1.5`, "1.5", 1.5, false, false},
+		{`-2`, "-2", -2, false, false},
+		{`1e18`, "1e18", 1e18, false, false},
+		{`"1.2E-3"`, "1.2E-3", 0.0012, false, false},
+		{`"115792089237316195423570985008687907853269984665640564039457584007913129639935"`, "115792089237316195423570985008687907853269984665640564039457584007913129639935", 1.157920892373162e77, false, false},
+		{`null`, "", 0, false, true},
+		{`""`, "", 0, false, true},
+		{`"abc"`, "abc", 0, false, true},
+		{`true`, "", 0, true, false},
+		{`{}`, "", 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var v struct{ N Numeric }
+			err := json.Unmarshal([]byte(`{"n":`+tt.json+`}`), &v)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decoding %s succeeded with %q, want error", tt.json, v.N)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decoding %s: %v", tt.json, err)
+			}
+			if v.N != tt.wantNumeric {
+				t.Errorf("decoding %s = %q, want %q", tt.json, v.N, tt.wantNumeric)
+			}
+
+			f, err := v.N.Float64()
+			if tt.wantParseErr {
+				if err == nil {
+					t.Errorf("%q.Float64() = %v, want error", v.N, f)
+				}
+				return
+			}
+			if err != nil || f != tt.wantFloat {
+				t.Errorf("%q.Float64() = %v, %v, want %v", v.N, f, err, tt.wantFloat)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package util
+
+import "time"
+
+// sample represents a value observed at a point in time.
+type sample struct {
+	time  time.Time
+	value float64
+}
+
+// RollingWindow keeps the samples observed within a fixed time window, so that trends can be
+// calculated over the same window regardless of how often samples are added. The newest sample
+// from before the window is kept as well, so that the trend covers the whole window. A
+// RollingWindow is not safe for concurrent use.
+type RollingWindow struct {
+	window  time.Duration
+	samples []sample
+}
+
+// NewRollingWindow creates a new RollingWindow that keeps the samples of the last window.
+func NewRollingWindow(window time.Duration) *RollingWindow {
+	return &RollingWindow{window: window}
+}
+
+// Add adds a sample and drops the samples that are no longer needed. Samples should be added in
+// chronological order.
+func (w *RollingWindow) Add(t time.Time, value float64) {
+	w.samples = append(w.samples, sample{time: t, value: value})
+
+	cutoff := t.Add(-w.window)
+	for len(w.samples) > 2 && !w.samples[1].time.After(cutoff) {
+		w.samples = w.samples[1:]
+	}
+}
+
+// Average returns the average of the samples within the window, leaving out the sample from before
+// the window. It returns false when the window does not contain any samples.
+func (w *RollingWindow) Average() (float64, bool) {
+	if len(w.samples) == 0 {
+		return 0, false
+	}
+
+	cutoff := w.samples[len(w.samples)-1].time.Add(-w.window)
+	var sum float64
+	var count int
+	for _, s := range w.samples {
+		if s.time.After(cutoff) {
+			sum += s.value
+			count++
+		}
+	}
+	return sum / float64(count), true
+}
+
+// Change returns the change between the oldest and newest sample in the window. It returns false
+// when the window does not contain two samples.
+func (w *RollingWindow) Change() (float64, bool) {
+	if len(w.samples) < 2 {
+		return 0, false
+	}
+	return w.samples[len(w.samples)-1].value - w.samples[0].value, true
+}
+
+// RatePerHour returns the change per hour between the oldest and newest sample in the window. It
+// returns false when the window does not contain two samples that are apart in time.
+func (w *RollingWindow) RatePerHour() (float64, bool) {
+	if len(w.samples) < 2 {
+		return 0, false
+	}
+
+	first, last := w.samples[0], w.samples[len(w.samples)-1]
+	hours := last.time.Sub(first.time).Hours()
+	if hours <= 0 {
+		return 0, false
+	}
+	return (last.value - first.value) / hours, true
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// start is the time of the first sample in the tests.
+var start = time.Unix(1704067200, 0)
+
+func TestRollingWindowEmpty(t *testing.T) {
+	w := NewRollingWindow(time.Hour)
+	if _, ok := w.Average(); ok {
+		t.Error("Average() of an empty window is ok")
+	}
+	if _, ok := w.Change(); ok {
+		t.Error("Change() of an empty window is ok")
+	}
+	if _, ok := w.RatePerHour(); ok {
+		t.Error("RatePerHour() of an empty window is ok")
+	}
+
+	// A single sample, as after a restart, has an average but no trend.
+	w.Add(start, 10)
+	if avg, ok := w.Average(); !ok || avg != 10 {
+		t.Errorf("Average() = %v, %v, want 10, true", avg, ok)
+	}
+	if _, ok := w.Change(); ok {
+		t.Error("Change() of a single sample is ok")
+	}
+	if _, ok := w.RatePerHour(); ok {
+		t.Error("RatePerHour() of a single sample is ok")
+	}
+}
+
+func TestRollingWindowBoundary(t *testing.T) {
+	w := NewRollingWindow(time.Hour)
+	w.Add(start, 10)
+	w.Add(start.Add(30*time.Minute), 20)
+	w.Add(start.Add(time.Hour), 40)
+
+	// The sample exactly one window back is kept as the start of the trend, but left out of the average.
+	if change, ok := w.Change(); !ok || change != 30 {
+		t.Errorf("Change() = %v, %v, want 30, true", change, ok)
+	}
+	if rate, ok := w.RatePerHour(); !ok || rate != 30 {
+		t.Errorf("RatePerHour() = %v, %v, want 30, true", rate, ok)
+	}
+	if avg, ok := w.Average(); !ok || avg != 30 {
+		t.Errorf("Average() = %v, %v, want 30, true", avg, ok)
+	}
+
+	// Once a newer sample is at least one window back, the older samples are dropped.
+	w.Add(start.Add(90*time.Minute), 50)
+	if change, ok := w.Change(); !ok || change != 30 {
+		t.Errorf("Change() = %v, %v, want 30, true", change, ok)
+	}
+	if rate, ok := w.RatePerHour(); !ok || rate != 30 {
+		t.Errorf("RatePerHour() = %v, %v, want 30, true", rate, ok)
+	}
+	if avg, ok := w.Average(); !ok || avg != 45 {
+		t.Errorf("Average() = %v, %v, want 45, true", avg, ok)
+	}
+}
+
+func TestRollingWindowKeepsSampleBeforeGap(t *testing.T) {
+	w := NewRollingWindow(time.Hour)
+	w.Add(start, 10)
+	w.Add(start.Add(3*time.Hour), 40)
+
+	// The newest sample from before the window is kept, so the trend spans the gap.
+	if rate, ok := w.RatePerHour(); !ok || rate != 10 {
+		t.Errorf("RatePerHour() = %v, %v, want 10, true", rate, ok)
+	}
+	if avg, ok := w.Average(); !ok || avg != 40 {
+		t.Errorf("Average() = %v, %v, want 40, true", avg, ok)
+	}
+}
+
+func TestRollingWindowSameTime(t *testing.T) {
+	w := NewRollingWindow(time.Hour)
+	w.Add(start, 10)
+	w.Add(start, 20)
+
+	if _, ok := w.RatePerHour(); ok {
+		t.Error("RatePerHour() of samples at the same time is ok")
+	}
+	if change, ok := w.Change(); !ok || change != 10 {
+		t.Errorf("Change() = %v, %v, want 10, true", change, ok)
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsVersions maps the supported TLS version names to their crypto/tls values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version such as '1.2' or '1.3'.
+func ParseTLSVersion(version string) (uint16, error) {
+	value, ok := tlsVersions[strings.TrimPrefix(version, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version '%s'", version)
+	}
+	return value, nil
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names as returned by
+// tls.CipherSuiteName (e.g. 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'). Insecure cipher suites are
+// rejected.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// LoadCertPool loads the PEM encoded certificates in the given file into a certificate pool.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in '%s'", path)
+	}
+	return pool, nil
+}
+
+// LoadRootCAs loads the PEM encoded certificates in the given file into a copy of the system
+// certificate pool, so that they are trusted in addition to the system root CAs.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in '%s'", path)
+	}
+	return pool, nil
+}